@@ -5,6 +5,7 @@ require (
 	github.com/pkg/errors v0.0.0-20161029093637-248dadf4e906
 	github.com/sirupsen/logrus v1.3.0
 	github.com/thoas/go-funk v0.7.0
+	golang.org/x/net v0.0.0-20170527060238-48359f4f600b
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 )
 
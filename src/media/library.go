@@ -0,0 +1,142 @@
+package media
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	clipsDir    = "clips"
+	maxClipSize = 10 << 20
+)
+
+var clipExtensions = map[string]bool{".mp3": true, ".wav": true, ".ogg": true, ".flac": true, ".aac": true, ".m4a": true}
+
+// Clip is an audio file in the clip library
+type Clip struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	URL  string `json:"url"`
+}
+
+// SaveClip stores the file in the library, existing clip with the same name is replaced
+func (s *Server) SaveClip(name string, data []byte) error {
+	if err := validateClipName(name); err != nil {
+		return err
+	}
+	if len(data) == 0 || len(data) > maxClipSize {
+		return fmt.Errorf("clip size must be between 1 byte and %d bytes", maxClipSize)
+	}
+	dir := filepath.Join(s.dir, clipsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(dir, ".upload")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), filepath.Join(dir, name))
+}
+
+// DownloadClip fetches the file from url and stores it in the library
+func (s *Server) DownloadClip(name, url string) error {
+	if err := validateClipName(name); err != nil {
+		return err
+	}
+	httpClient := &http.Client{Timeout: 60 * time.Second}
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed with status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxClipSize+1))
+	if err != nil {
+		return err
+	}
+	return s.SaveClip(name, data)
+}
+
+// ListClips returns library clips sorted by name
+func (s *Server) ListClips() ([]Clip, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, clipsDir))
+	if os.IsNotExist(err) {
+		return []Clip{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	clips := []Clip{}
+	for _, file := range files {
+		if file.IsDir() || validateClipName(file.Name()) != nil {
+			continue
+		}
+		clips = append(clips, Clip{Name: file.Name(), Size: file.Size(), URL: s.URL(filepath.Join(clipsDir, file.Name()))})
+	}
+	sort.Slice(clips, func(i, j int) bool { return clips[i].Name < clips[j].Name })
+	return clips, nil
+}
+
+// RenameClip renames the clip, the new name must not be used by another clip
+func (s *Server) RenameClip(name, newName string) error {
+	if err := validateClipName(name); err != nil {
+		return err
+	}
+	if err := validateClipName(newName); err != nil {
+		return err
+	}
+	newPath := filepath.Join(s.dir, clipsDir, newName)
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("clip %s already exists", newName)
+	}
+	return os.Rename(filepath.Join(s.dir, clipsDir, name), newPath)
+}
+
+func (s *Server) DeleteClip(name string) error {
+	if err := validateClipName(name); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(s.dir, clipsDir, name))
+}
+
+// ClipURL returns url of the library clip which can be used as audio clip stream url
+func (s *Server) ClipURL(name string) (string, error) {
+	if err := validateClipName(name); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, clipsDir, name)); err != nil {
+		return "", fmt.Errorf("clip %s not found", name)
+	}
+	return s.URL(filepath.Join(clipsDir, name)), nil
+}
+
+// validateClipName allows plain file names with audio extension, so clips can't be written outside of the library
+func validateClipName(name string) error {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid clip name %q", name)
+	}
+	if !clipExtensions[strings.ToLower(filepath.Ext(name))] {
+		return fmt.Errorf("clip %s must be mp3, wav, ogg, flac, aac or m4a file", name)
+	}
+	return nil
+}
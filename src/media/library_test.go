@@ -0,0 +1,44 @@
+package media
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestServer_ClipLibrary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := NewServer(dir, "hub", 8093, nil)
+
+	if err := server.SaveClip("door bell.mp3", []byte("ID3")); err != nil {
+		t.Fatal("Can't save clip. Err:", err)
+	}
+	for _, name := range []string{"../config.json", "clip.exe", ".hidden.mp3", ""} {
+		if err := server.SaveClip(name, []byte("ID3")); err == nil {
+			t.Fatalf("Clip name %q must be rejected", name)
+		}
+	}
+	if err := server.RenameClip("door bell.mp3", "chime.mp3"); err != nil {
+		t.Fatal("Can't rename clip. Err:", err)
+	}
+	clips, err := server.ListClips()
+	if err != nil || len(clips) != 1 || clips[0].Name != "chime.mp3" || clips[0].Size != 3 {
+		t.Fatalf("Unexpected clips %v, err %v", clips, err)
+	}
+	if url, err := server.ClipURL("chime.mp3"); err != nil || url != "http://hub:8093/media/clips/chime.mp3" {
+		t.Fatalf("Unexpected url %s, err %v", url, err)
+	}
+	if server.URL("clips/door bell.mp3") != "http://hub:8093/media/clips/door%20bell.mp3" {
+		t.Fatal("Url must be escaped")
+	}
+	if err := server.DeleteClip("chime.mp3"); err != nil {
+		t.Fatal("Can't delete clip. Err:", err)
+	}
+	if _, err := server.ClipURL("chime.mp3"); err == nil {
+		t.Fatal("Deleted clip must not be found")
+	}
+}
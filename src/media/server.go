@@ -0,0 +1,178 @@
+package media
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	pathPrefix   = "/media/"
+	ttsDir       = "tts"
+	ttsTmpPrefix = "tts-"
+	// ttsCacheMaxSize limits generated speech files, the least recently used files are removed first
+	ttsCacheMaxSize = 50 << 20
+)
+
+// Server serves audio files from the data dir to the players, players fetch clips over the LAN
+type Server struct {
+	dir        string
+	host       string
+	port       int
+	tts        TTSBackend
+	httpServer *http.Server
+}
+
+// NewServer creates media server for files in dir. If host is empty the first non loopback IPv4 address of the hub is used.
+func NewServer(dir, host string, port int, tts TTSBackend) *Server {
+	return &Server{dir: dir, host: host, port: port, tts: tts}
+}
+
+func (s *Server) Start() error {
+	for _, dir := range []string{ttsDir, clipsDir} {
+		if err := os.MkdirAll(filepath.Join(s.dir, dir), 0755); err != nil {
+			return err
+		}
+	}
+	if s.host == "" {
+		s.host = localIP()
+	}
+	mux := http.NewServeMux()
+	mux.Handle(pathPrefix, http.StripPrefix(pathPrefix, http.FileServer(filesOnly{http.Dir(s.dir)})))
+	s.httpServer = &http.Server{Addr: fmt.Sprintf(":%d", s.port), Handler: mux}
+	listener, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	// clip urls must point to the port actually bound, e.g. when port 0 selects a free port
+	s.port = listener.Addr().(*net.TCPAddr).Port
+	go func() {
+		if err := s.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error("<media> Server stopped. Err:", err)
+		}
+	}()
+	log.Infof("<media> Serving %s on port %d", s.dir, s.port)
+	return nil
+}
+
+func (s *Server) Stop() {
+	if s.httpServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.httpServer.Shutdown(ctx)
+}
+
+// URL returns address of the file relative to the media dir, as seen by the players
+func (s *Server) URL(name string) string {
+	path := &url.URL{Path: pathPrefix + filepath.ToSlash(name)}
+	return fmt.Sprintf("http://%s:%d%s", s.host, s.port, path.EscapedPath())
+}
+
+// Speak converts the text into a wav file and returns its url. Files are cached, the same text is generated only once.
+func (s *Server) Speak(text, lang string) (string, error) {
+	if s.tts == nil {
+		return "", fmt.Errorf("text to speech is not available")
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", fmt.Errorf("text is empty")
+	}
+	hash := sha1.Sum([]byte(lang + "/" + text))
+	name := filepath.Join(ttsDir, hex.EncodeToString(hash[:])+".wav")
+	path := filepath.Join(s.dir, name)
+	if _, err := os.Stat(path); err == nil {
+		// cache hit, keeps the file from being pruned as least recently used
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return s.URL(name), nil
+	}
+	// parallel requests of the same text write separate files, the complete file is renamed into place
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), ttsTmpPrefix+"*.wav")
+	if err != nil {
+		return "", err
+	}
+	tmpFile.Close()
+	if err := s.tts.Synthesize(text, lang, tmpFile.Name()); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+	s.pruneTTSCache(ttsCacheMaxSize)
+	return s.URL(name), nil
+}
+
+// pruneTTSCache removes the least recently used speech files until the cache is not larger than maxSize
+func (s *Server) pruneTTSCache(maxSize int64) {
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, ttsDir))
+	if err != nil {
+		log.Error("<media> Can't read speech cache. Err:", err)
+		return
+	}
+	var size int64
+	var cached []os.FileInfo
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ttsTmpPrefix) {
+			continue
+		}
+		size += file.Size()
+		cached = append(cached, file)
+	}
+	sort.Slice(cached, func(i, j int) bool { return cached[i].ModTime().Before(cached[j].ModTime()) })
+	for _, file := range cached {
+		if size <= maxSize {
+			return
+		}
+		if err := os.Remove(filepath.Join(s.dir, ttsDir, file.Name())); err != nil {
+			log.Error("<media> Can't remove cached speech. Err:", err)
+			continue
+		}
+		size -= file.Size()
+	}
+}
+
+// filesOnly serves files but no directory listings, directories are reported as not found
+type filesOnly struct {
+	fs http.FileSystem
+}
+
+func (f filesOnly) Open(name string) (http.File, error) {
+	file, err := f.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		file.Close()
+		return nil, os.ErrNotExist
+	}
+	return file, nil
+}
+
+func localIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "127.0.0.1"
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+	}
+	return "127.0.0.1"
+}
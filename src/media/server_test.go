@@ -0,0 +1,129 @@
+package media
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeTTS struct {
+	mux   sync.Mutex
+	calls int
+}
+
+func (f *fakeTTS) Synthesize(text, lang, outPath string) error {
+	f.mux.Lock()
+	f.calls++
+	f.mux.Unlock()
+	return ioutil.WriteFile(outPath, []byte("RIFF"+lang+text), 0644)
+}
+
+func TestServer_Speak(t *testing.T) {
+	dir, err := ioutil.TempDir("", "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tts := &fakeTTS{}
+	// port 0 selects a free port, urls must use the bound port
+	server := NewServer(dir, "127.0.0.1", 0, tts)
+	if err := server.Start(); err != nil {
+		t.Fatal("Can't start server. Err:", err)
+	}
+	defer server.Stop()
+
+	url, err := server.Speak("Front door opened", "en-US")
+	if err != nil {
+		t.Fatal("Can't speak. Err:", err)
+	}
+	if _, err := server.Speak("Front door opened", "en-US"); err != nil || tts.calls != 1 {
+		t.Fatalf("Speech must be cached, backend called %d times", tts.calls)
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Can't fetch ", url, " Err:", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "RIFFen-USFront door opened" {
+		t.Fatalf("Unexpected response %d %s", resp.StatusCode, body)
+	}
+	if _, err := server.Speak(" ", "en-US"); err == nil {
+		t.Fatal("Empty text must fail")
+	}
+
+	for _, dir := range []string{"", "tts/"} {
+		resp, err := http.Get(server.URL(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Directory %q must not be listed, got status %d", dir, resp.StatusCode)
+		}
+	}
+}
+
+func TestServer_SpeakParallel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := NewServer(dir, "127.0.0.1", 0, &fakeTTS{})
+	if err := os.MkdirAll(filepath.Join(dir, ttsDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// two groups announce the same text at once
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := server.Speak("Front door opened", "en-US"); err != nil {
+				t.Error("Can't speak. Err:", err)
+			}
+		}()
+	}
+	wg.Wait()
+	files, _ := ioutil.ReadDir(filepath.Join(dir, ttsDir))
+	if len(files) != 1 {
+		t.Fatalf("Temporary files must be removed, got %d files", len(files))
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, ttsDir, files[0].Name()))
+	if string(data) != "RIFFen-USFront door opened" {
+		t.Fatalf("Unexpected file content %s", data)
+	}
+}
+
+func TestServer_PruneTTSCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := NewServer(dir, "127.0.0.1", 0, &fakeTTS{})
+	ttsPath := filepath.Join(dir, ttsDir)
+	if err := os.MkdirAll(ttsPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i, name := range []string{"old.wav", "recent.wav", "new.wav"} {
+		path := filepath.Join(ttsPath, name)
+		ioutil.WriteFile(path, []byte(strings.Repeat("x", 10)), 0644)
+		modTime := now.Add(time.Duration(i-3) * time.Hour)
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	server.pruneTTSCache(20)
+	files, _ := ioutil.ReadDir(ttsPath)
+	if len(files) != 2 || files[0].Name() != "new.wav" || files[1].Name() != "recent.wav" {
+		t.Fatalf("Least recently used file must be removed, got %v", files)
+	}
+}
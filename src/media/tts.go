@@ -0,0 +1,87 @@
+package media
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TTSBackend converts text into a wav file at outPath. lang is a language tag like en-US or nb-NO.
+type TTSBackend interface {
+	Synthesize(text, lang, outPath string) error
+}
+
+// picoLanguages are the languages pico2wave can speak, lowercase
+var picoLanguages = map[string]bool{"en-us": true, "en-gb": true, "de-de": true, "es-es": true, "fr-fr": true, "it-it": true}
+
+// PicoTTS uses offline pico2wave engine, it sounds better than espeak but supports only picoLanguages
+type PicoTTS struct{}
+
+func (PicoTTS) Synthesize(text, lang, outPath string) error {
+	if lang == "" {
+		lang = "en-US"
+	}
+	return run("pico2wave", "-l", lang, "-w", outPath, text)
+}
+
+// EspeakTTS uses offline espeak or espeak-ng engine, it supports more languages than pico (e.g. Norwegian)
+type EspeakTTS struct {
+	Command string
+}
+
+func (e EspeakTTS) Synthesize(text, lang, outPath string) error {
+	args := []string{"-w", outPath}
+	if lang != "" {
+		// espeak uses lowercase language codes without region, e.g. nb instead of nb-NO
+		args = append(args, "-v", strings.ToLower(strings.SplitN(lang, "-", 2)[0]))
+	}
+	return run(e.Command, append(args, text)...)
+}
+
+// languageTTS chooses the engine per language, pico for its languages and espeak for the rest
+type languageTTS struct {
+	pico   TTSBackend
+	espeak TTSBackend
+}
+
+func (t languageTTS) Synthesize(text, lang, outPath string) error {
+	if t.pico != nil && (lang == "" || picoLanguages[strings.ToLower(lang)]) {
+		err := t.pico.Synthesize(text, lang, outPath)
+		if err == nil || t.espeak == nil {
+			return err
+		}
+		log.Warn("<media> pico2wave failed, falling back to espeak. Err:", err)
+	}
+	if t.espeak == nil {
+		return fmt.Errorf("language %s is not supported by installed text to speech engine", lang)
+	}
+	return t.espeak.Synthesize(text, lang, outPath)
+}
+
+// NewLocalTTS returns offline engines installed on the hub, or nil if none is available
+func NewLocalTTS() TTSBackend {
+	var tts languageTTS
+	if _, err := exec.LookPath("pico2wave"); err == nil {
+		tts.pico = PicoTTS{}
+	}
+	for _, command := range []string{"espeak-ng", "espeak"} {
+		if _, err := exec.LookPath(command); err == nil {
+			tts.espeak = EspeakTTS{Command: command}
+			break
+		}
+	}
+	if tts.pico == nil && tts.espeak == nil {
+		return nil
+	}
+	return tts
+}
+
+func run(command string, args ...string) error {
+	out, err := exec.Command(command, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v %s", command, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
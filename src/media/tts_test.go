@@ -0,0 +1,42 @@
+package media
+
+import (
+	"fmt"
+	"testing"
+)
+
+// recordingTTS records languages it was asked to speak
+type recordingTTS struct {
+	langs []string
+	err   error
+}
+
+func (r *recordingTTS) Synthesize(text, lang, outPath string) error {
+	r.langs = append(r.langs, lang)
+	return r.err
+}
+
+func TestLanguageTTS(t *testing.T) {
+	pico, espeak := &recordingTTS{}, &recordingTTS{}
+	tts := languageTTS{pico: pico, espeak: espeak}
+	for _, lang := range []string{"en-US", "de-de", "", "nb-NO", "sv-SE"} {
+		if err := tts.Synthesize("Hello", lang, "out.wav"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(pico.langs) != "[en-US de-de ]" || fmt.Sprint(espeak.langs) != "[nb-NO sv-SE]" {
+		t.Fatalf("Unexpected engines, pico: %v, espeak: %v", pico.langs, espeak.langs)
+	}
+
+	// failed pico falls back to espeak
+	pico.err = fmt.Errorf("pico2wave failed")
+	if err := tts.Synthesize("Hello", "fr-FR", "out.wav"); err != nil || espeak.langs[len(espeak.langs)-1] != "fr-FR" {
+		t.Fatalf("Espeak must be used when pico fails, err: %v", err)
+	}
+
+	// without espeak, languages pico can't speak are rejected
+	tts = languageTTS{pico: &recordingTTS{}}
+	if err := tts.Synthesize("Hei", "nb-NO", "out.wav"); err == nil {
+		t.Fatal("Unsupported language must fail")
+	}
+}
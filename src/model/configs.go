@@ -6,8 +6,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/futurehomeno/edge-sonos-adapter/sonos-api"
 	"github.com/futurehomeno/edge-sonos-adapter/utils"
 	fgutils "github.com/futurehomeno/fimpgo/utils"
 	log "github.com/sirupsen/logrus"
@@ -15,7 +17,13 @@ import (
 
 const ServiceName = "sonos"
 
+// DefaultMediaServerPort is used by configs created before media_server_port was added
+const DefaultMediaServerPort = 8093
+
+// Configs is read by the poll loop and changed by the FIMP router. Fields written after startup are accessed
+// through Update and getters, which lock.
 type Configs struct {
+	mu                 sync.RWMutex
 	path               string
 	InstanceAddress    string        `json:"instance_address"`
 	MqttServerURI      string        `json:"mqtt_server_uri"`
@@ -32,6 +40,11 @@ type Configs struct {
 	RefreshToken       string        `json:"refresh_token"`
 	ExpiresIn          int           `json:"expires_in"`
 	WantedHouseholds   []interface{} `json:"households"`
+	LocalHouseholds    []string      `json:"local_households"` // households controlled directly over the player WebSocket
+	LocalApiKey        string        `json:"local_api_key"`
+	PositionInterval   int           `json:"position_report_interval"` // seconds between position reports while playing, 0 - disabled
+	MediaServerHost    string        `json:"media_server_host"`        // address of the hub used in clip urls, empty - detected automatically
+	MediaServerPort    int           `json:"media_server_port"`
 	LastAuthMillis     int64
 	Env                string
 }
@@ -56,10 +69,15 @@ func (cf *Configs) LoadFromFile() error {
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(configFileBody, cf)
+	cf.mu.Lock()
+	err = json.Unmarshal(configFileBody, (*configsJSON)(cf))
+	cf.mu.Unlock()
 	if err != nil {
 		return err
 	}
+	if cf.MediaServerPort == 0 {
+		cf.MediaServerPort = DefaultMediaServerPort
+	}
 	if cf.Env == "" {
 		log.Info("Environment is not set. Configuring..")
 		hubInfo, err := fgutils.NewHubUtils().GetHubInfo()
@@ -74,10 +92,16 @@ func (cf *Configs) LoadFromFile() error {
 	return nil
 }
 
+// SaveToFile writes configs to file, it must not be called inside Update
 func (cf *Configs) SaveToFile() error {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
 	cf.ConfiguredBy = "auto"
 	cf.ConfiguredAt = time.Now().Format(time.RFC3339)
-	bpayload, err := json.Marshal(cf)
+	bpayload, err := json.Marshal((*configsJSON)(cf))
+	if err != nil {
+		return err
+	}
 	err = ioutil.WriteFile(cf.path, bpayload, 0664)
 	if err != nil {
 		return err
@@ -101,7 +125,78 @@ func (cf *Configs) LoadDefaults() error {
 	return utils.CopyFile(defaultConfigFile, configFile)
 }
 
+// configsJSON has the same fields as Configs without MarshalJSON, it is used when the lock is already held
+type configsJSON Configs
+
+// MarshalJSON locks configs, so they can be sent in reports while the poll loop updates them
+func (cf *Configs) MarshalJSON() ([]byte, error) {
+	cf.mu.RLock()
+	defer cf.mu.RUnlock()
+	return json.Marshal((*configsJSON)(cf))
+}
+
+// Update runs fn with write lock held
+func (cf *Configs) Update(fn func(cf *Configs)) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	fn(cf)
+}
+
+// GetWantedHouseholds returns ids of households selected by the user
+func (cf *Configs) GetWantedHouseholds() []string {
+	cf.mu.RLock()
+	defer cf.mu.RUnlock()
+	var households []string
+	for _, household := range cf.WantedHouseholds {
+		households = append(households, fmt.Sprintf("%v", household))
+	}
+	return households
+}
+
+func (cf *Configs) GetPositionInterval() int {
+	cf.mu.RLock()
+	defer cf.mu.RUnlock()
+	return cf.PositionInterval
+}
+
+// GetTokens returns saved tokens. ExpiresIn is relative to LastAuthMillis, if it wasn't saved the token is assumed
+// to be valid for 12 hours after the last authorization.
+func (cf *Configs) GetTokens() sonos.Tokens {
+	cf.mu.RLock()
+	defer cf.mu.RUnlock()
+	tokens := sonos.Tokens{AccessToken: cf.AccessToken, RefreshToken: cf.RefreshToken}
+	if cf.AccessToken == "" || cf.LastAuthMillis == 0 {
+		return tokens
+	}
+	lifetime := time.Duration(cf.ExpiresIn) * time.Second
+	if cf.ExpiresIn <= 0 {
+		lifetime = 12 * time.Hour
+	}
+	tokens.ExpiresAt = time.Unix(0, cf.LastAuthMillis*int64(time.Millisecond)).Add(lifetime)
+	return tokens
+}
+
+// SetTokens stores tokens, it doesn't save them to file
+func (cf *Configs) SetTokens(tokens sonos.Tokens) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	now := time.Now()
+	cf.AccessToken = tokens.AccessToken
+	cf.RefreshToken = tokens.RefreshToken
+	cf.LastAuthMillis = now.UnixNano() / int64(time.Millisecond)
+	cf.ExpiresIn = int((tokens.ExpiresAt.Sub(now) + time.Second/2) / time.Second)
+	if tokens.ExpiresAt.IsZero() || tokens.AccessToken == "" {
+		cf.LastAuthMillis, cf.ExpiresIn = 0, 0
+	}
+}
+
 func (cf *Configs) IsAuthenticated() bool {
+	cf.mu.RLock()
+	defer cf.mu.RUnlock()
+	return cf.isAuthenticated()
+}
+
+func (cf *Configs) isAuthenticated() bool {
 	if cf.AccessToken != "" && cf.AccessToken != "access_token" {
 		return true
 	}
@@ -109,7 +204,9 @@ func (cf *Configs) IsAuthenticated() bool {
 }
 
 func (cf *Configs) IsConfigured() bool {
-	if cf.IsAuthenticated() && len(cf.WantedHouseholds) > 0 {
+	cf.mu.RLock()
+	defer cf.mu.RUnlock()
+	if cf.isAuthenticated() && len(cf.WantedHouseholds) > 0 {
 		return true
 	}
 	return false
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/futurehomeno/edge-sonos-adapter/sonos-api"
+)
+
+func TestConfigs_ConcurrentAccess(t *testing.T) {
+	configs := &Configs{WantedHouseholds: []interface{}{"HH_1"}}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			configs.Update(func(cf *Configs) {
+				cf.AccessToken = "token"
+				cf.WantedHouseholds = []interface{}{"HH_1", "HH_2"}
+			})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			configs.GetWantedHouseholds()
+			configs.IsConfigured()
+			if _, err := json.Marshal(configs); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	if households := configs.GetWantedHouseholds(); len(households) != 2 || households[1] != "HH_2" {
+		t.Fatalf("Unexpected households %v", households)
+	}
+	body, _ := json.Marshal(configs)
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil || decoded["access_token"] != "token" {
+		t.Fatalf("Unexpected json %s", body)
+	}
+}
+
+func TestConfigs_Tokens(t *testing.T) {
+	configs := &Configs{}
+	tokens := sonos.NewTokens("access", "refresh", 3600)
+	configs.SetTokens(tokens)
+	saved := configs.GetTokens()
+	if saved.AccessToken != "access" || saved.RefreshToken != "refresh" || saved.ExpiresAt.Sub(tokens.ExpiresAt) > time.Second || tokens.ExpiresAt.Sub(saved.ExpiresAt) > time.Second {
+		t.Fatalf("Unexpected tokens %+v, expected %+v", saved, tokens)
+	}
+
+	// configs saved before expires_in was stored
+	configs = &Configs{AccessToken: "access", LastAuthMillis: time.Now().Add(-13*time.Hour).UnixNano() / int64(time.Millisecond)}
+	if expiresAt := configs.GetTokens().ExpiresAt; !expiresAt.Before(time.Now()) {
+		t.Fatalf("Token must be expired 12 hours after login, expires at %s", expiresAt)
+	}
+}
+
+func TestConfigs_DefaultMediaServerPort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// config saved by a version without media server
+	configs := &Configs{path: filepath.Join(dir, "config.json")}
+	if err := ioutil.WriteFile(configs.path, []byte(`{"log_level":"info","Env":"beta"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := configs.LoadFromFile(); err != nil {
+		t.Fatal("Can't load configs. Err:", err)
+	}
+	if configs.MediaServerPort != DefaultMediaServerPort {
+		t.Fatalf("Missing port must default to %d, got %d", DefaultMediaServerPort, configs.MediaServerPort)
+	}
+}
@@ -1,11 +1,11 @@
 package model
 
 import (
+	"context"
 	log "github.com/sirupsen/logrus"
 	"sync"
 )
 
-
 //
 // Events : STATING -> CONFIGURING -> CONFIGURED -> RUNNING
 // States : CONFIGURING -> RUNNING  / CONFIGURING -> NOT_CONFIGURED /
@@ -21,28 +21,28 @@ const (
 	AppStateRunning       = "RUNNING"
 	AppStateTerminate     = "TERMINATING"
 
-	ConfigStateNotConfigured = "NOT_CONFIGURED"
-	ConfigStateConfigured    = "CONFIGURED"
-	ConfigStatePartConfigured= "PART_CONFIGURED"
-	ConfigStateInProgress    = "IN_PROGRESS"
-	ConfigStateNA            = "NA"
+	ConfigStateNotConfigured  = "NOT_CONFIGURED"
+	ConfigStateConfigured     = "CONFIGURED"
+	ConfigStatePartConfigured = "PART_CONFIGURED"
+	ConfigStateInProgress     = "IN_PROGRESS"
+	ConfigStateNA             = "NA"
 
 	AuthStateNotAuthenticated = "NOT_AUTHENTICATED"
 	AuthStateAuthenticated    = "AUTHENTICATED"
 	AuthStateInProgress       = "IN_PROGRESS"
 	AuthStateNA               = "NA"
 
-	ConnStateConnecting      = "CONNECTING"
-	ConnStateConnected       = "CONNECTED"
-	ConnStateDisconnected    = "DISCONNECTED"
-	ConnStateNA              = "NA"
+	ConnStateConnecting   = "CONNECTING"
+	ConnStateConnected    = "CONNECTED"
+	ConnStateDisconnected = "DISCONNECTED"
+	ConnStateThrottled    = "THROTTLED" // Sonos rate limits requests
+	ConnStateNA           = "NA"
 
 	//EventStarting            = "STARTING"
-	EventConfiguring         = "CONFIGURING"          // All configurations loaded and brokers configured
-	EventConfigError         = "CONF_ERROR"           // All configurations loaded and brokers configured
-	EventConfigured          = "CONFIGURED"          // All configurations loaded and brokers configured
-	EventRunning             = "RUNNING"
-
+	EventConfiguring = "CONFIGURING" // All configurations loaded and brokers configured
+	EventConfigError = "CONF_ERROR"  // All configurations loaded and brokers configured
+	EventConfigured  = "CONFIGURED"  // All configurations loaded and brokers configured
+	EventRunning     = "RUNNING"
 )
 
 type State string
@@ -68,15 +68,23 @@ type SystemEventChannel chan SystemEvent
 
 type Lifecycle struct {
 	busMux           sync.Mutex
+	stateMux         sync.RWMutex // protects states, they are read and set from several goroutines
 	systemEventBus   map[string]SystemEventChannel
 	appState         State
 	previousAppState State
 	connectionState  State
 	authState        State
 	configState      State
+	lastErrorCode    string
+	lastErrorText    string
+	workMux          sync.Mutex
+	workCtx          context.Context // parent context of Sonos requests, canceled by CancelWork
+	cancelWork       context.CancelFunc
 }
+
 func NewAppLifecycle() *Lifecycle {
 	lf := &Lifecycle{systemEventBus: make(map[string]SystemEventChannel)}
+	lf.workCtx, lf.cancelWork = context.WithCancel(context.Background())
 	lf.appState = AppStateStarting
 	lf.authState = AuthStateNA
 	lf.configState = ConfigStateNotConfigured
@@ -85,55 +93,97 @@ func NewAppLifecycle() *Lifecycle {
 }
 
 func (al *Lifecycle) GetAllStates() *AppStates {
+	al.stateMux.RLock()
+	defer al.stateMux.RUnlock()
 	appStates := AppStates{
-		App:          string(al.appState),
-		Connection:   string(al.connectionState),
-		Config:       string(al.configState),
-		Auth:         string(al.authState),
-		LastErrorText: "",
-		LastErrorCode: "",
+		App:           string(al.appState),
+		Connection:    string(al.connectionState),
+		Config:        string(al.configState),
+		Auth:          string(al.authState),
+		LastErrorText: al.lastErrorText,
+		LastErrorCode: al.lastErrorCode,
 	}
 	return &appStates
 }
 
+// SetLastError sets the error reported in app state, e.g. why the user has to log in again. Empty code clears it.
+func (al *Lifecycle) SetLastError(code, text string) {
+	al.stateMux.Lock()
+	al.lastErrorCode = code
+	al.lastErrorText = text
+	al.stateMux.Unlock()
+}
+
+// Context returns the context of outstanding work, requests derived from it are canceled by CancelWork
+func (al *Lifecycle) Context() context.Context {
+	al.workMux.Lock()
+	defer al.workMux.Unlock()
+	return al.workCtx
+}
 
+// CancelWork cancels all outstanding work, e.g. on logout or factory reset. Work started afterwards uses a new context.
+func (al *Lifecycle) CancelWork() {
+	al.workMux.Lock()
+	al.cancelWork()
+	al.workCtx, al.cancelWork = context.WithCancel(context.Background())
+	al.workMux.Unlock()
+}
 
 func (al *Lifecycle) ConfigState() State {
+	al.stateMux.RLock()
+	defer al.stateMux.RUnlock()
 	return al.configState
 }
 
 func (al *Lifecycle) SetConfigState(configState State) {
 	log.Info("<sysEvt> New config state = ", configState)
+	al.stateMux.Lock()
 	al.configState = configState
+	al.stateMux.Unlock()
 }
 
 func (al *Lifecycle) AuthState() State {
+	al.stateMux.RLock()
+	defer al.stateMux.RUnlock()
 	return al.authState
 }
 
 func (al *Lifecycle) SetAuthState(authState State) {
 	log.Info("<sysEvt> New auth state = ", authState)
+	al.stateMux.Lock()
 	al.authState = authState
+	al.stateMux.Unlock()
 }
 
 func (al *Lifecycle) ConnectionState() State {
+	al.stateMux.RLock()
+	defer al.stateMux.RUnlock()
 	return al.connectionState
 }
 
 func (al *Lifecycle) SetConnectionState(connectivityState State) {
 	log.Info("<sysEvt> New connection state = ", connectivityState)
+	al.stateMux.Lock()
 	al.connectionState = connectivityState
+	al.stateMux.Unlock()
 }
 
 func (al *Lifecycle) AppState() State {
+	al.stateMux.RLock()
+	defer al.stateMux.RUnlock()
 	return al.appState
 }
 
 func (al *Lifecycle) SetAppState(currentState State, params map[string]string) {
 	al.busMux.Lock()
+	al.stateMux.Lock()
 	al.previousAppState = al.appState
 	al.appState = currentState
+	al.stateMux.Unlock()
 	log.Info("<sysEvt> New app state = ", currentState)
+	if currentState == AppStateTerminate {
+		al.CancelWork()
+	}
 	for i := range al.systemEventBus {
 		select {
 		case al.systemEventBus[i] <- SystemEvent{Type: SystemEventTypeState, State: currentState, Info: "sys", Params: params}:
@@ -145,9 +195,9 @@ func (al *Lifecycle) SetAppState(currentState State, params map[string]string) {
 	al.busMux.Unlock()
 }
 
-func (al *Lifecycle) PublishEvent(name,src string, params map[string]string) {
-	event := SystemEvent{Name:name}
-	al.Publish(event,src,params)
+func (al *Lifecycle) PublishEvent(name, src string, params map[string]string) {
+	event := SystemEvent{Name: name}
+	al.Publish(event, src, params)
 }
 
 func (al *Lifecycle) Publish(event SystemEvent, src string, params map[string]string) {
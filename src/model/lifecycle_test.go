@@ -0,0 +1,64 @@
+package model
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestLifecycle_ConcurrentStates(t *testing.T) {
+	lifecycle := NewAppLifecycle()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if i%2 == 0 {
+					lifecycle.SetAuthState(AuthStateAuthenticated)
+					lifecycle.SetConnectionState(ConnStateConnected)
+					lifecycle.SetConfigState(ConfigStateConfigured)
+					lifecycle.SetAppState(AppStateRunning, nil)
+				} else {
+					lifecycle.GetAllStates()
+					lifecycle.AuthState()
+					lifecycle.ConnectionState()
+					lifecycle.AppState()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if states := lifecycle.GetAllStates(); states.Auth != AuthStateAuthenticated || states.App != AppStateRunning {
+		t.Fatalf("Unexpected states %+v", states)
+	}
+}
+
+func TestLifecycle_LastError(t *testing.T) {
+	lifecycle := NewAppLifecycle()
+	lifecycle.SetLastError("TOKEN_REVOKED", "refresh token revoked")
+	if states := lifecycle.GetAllStates(); states.LastErrorCode != "TOKEN_REVOKED" || states.LastErrorText != "refresh token revoked" {
+		t.Fatalf("Unexpected states %+v", states)
+	}
+	lifecycle.SetLastError("", "")
+	if states := lifecycle.GetAllStates(); states.LastErrorCode != "" || states.LastErrorText != "" {
+		t.Fatalf("Error must be cleared, got %+v", states)
+	}
+}
+
+func TestLifecycle_CancelWork(t *testing.T) {
+	lifecycle := NewAppLifecycle()
+	ctx := lifecycle.Context()
+	lifecycle.CancelWork()
+	if ctx.Err() != context.Canceled {
+		t.Fatal("Outstanding work must be canceled")
+	}
+	if lifecycle.Context().Err() != nil {
+		t.Fatal("New work must not be canceled")
+	}
+	ctx = lifecycle.Context()
+	lifecycle.SetAppState(AppStateTerminate, nil)
+	if ctx.Err() != context.Canceled {
+		t.Fatal("Work must be canceled when the app terminates")
+	}
+}
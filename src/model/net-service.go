@@ -24,6 +24,21 @@ func (ns *NetworkService) MakeInclusionReport(Player sonos.Player) fimptype.Thin
 		MsgType:   "cmd.playback.set",
 		ValueType: "string",
 		Version:   "1",
+	}, {
+		Type:      "in",
+		MsgType:   "cmd.playback.seek",
+		ValueType: "int",
+		Version:   "1",
+	}, {
+		Type:      "in",
+		MsgType:   "cmd.playback.load_line_in",
+		ValueType: "string",
+		Version:   "1",
+	}, {
+		Type:      "in",
+		MsgType:   "cmd.playback.load_url",
+		ValueType: "object",
+		Version:   "1",
 	}, {
 		Type:      "in",
 		MsgType:   "cmd.playback.get_report",
@@ -34,6 +49,16 @@ func (ns *NetworkService) MakeInclusionReport(Player sonos.Player) fimptype.Thin
 		MsgType:   "evt.playback.report",
 		ValueType: "string",
 		Version:   "1",
+	}, {
+		Type:      "out",
+		MsgType:   "evt.playback.capabilities_report",
+		ValueType: "bool_map",
+		Version:   "1",
+	}, {
+		Type:      "out",
+		MsgType:   "evt.error.report",
+		ValueType: "string",
+		Version:   "1",
 	}, {
 		Type:      "in",
 		MsgType:   "cmd.playbackmode.set",
@@ -54,6 +79,11 @@ func (ns *NetworkService) MakeInclusionReport(Player sonos.Player) fimptype.Thin
 		MsgType:   "cmd.volume.set",
 		ValueType: "int",
 		Version:   "1",
+	}, {
+		Type:      "in",
+		MsgType:   "cmd.volume.step",
+		ValueType: "int",
+		Version:   "1",
 	}, {
 		Type:      "in",
 		MsgType:   "cmd.volume.get_report",
@@ -124,8 +154,97 @@ func (ns *NetworkService) MakeInclusionReport(Player sonos.Player) fimptype.Thin
 		MsgType:   "cmd.audioclip.play",
 		ValueType: "object",
 		Version:   "1",
+	}, {
+		Type:      "in",
+		MsgType:   "cmd.audioclip.say",
+		ValueType: "object",
+		Version:   "1",
+	}, {
+		Type:      "in",
+		MsgType:   "cmd.audioclip.cancel",
+		ValueType: "string",
+		Version:   "1",
+	}, {
+		Type:      "out",
+		MsgType:   "evt.audioclip.report",
+		ValueType: "str_map",
+		Version:   "1",
+	}, {
+		Type:      "in",
+		MsgType:   "cmd.state.get_report",
+		ValueType: "null",
+		Version:   "1",
+	}, {
+		Type:      "out",
+		MsgType:   "evt.state.report",
+		ValueType: "object",
+		Version:   "1",
+	}, {
+		Type:      "in",
+		MsgType:   "cmd.state.snapshot",
+		ValueType: "null",
+		Version:   "1",
+	}, {
+		Type:      "out",
+		MsgType:   "evt.state.snapshot_report",
+		ValueType: "object",
+		Version:   "1",
+	}, {
+		Type:      "in",
+		MsgType:   "cmd.state.restore",
+		ValueType: "null",
+		Version:   "1",
+	}, {
+		Type:      "in",
+		MsgType:   "cmd.group.join",
+		ValueType: "string",
+		Version:   "1",
+	}, {
+		Type:      "in",
+		MsgType:   "cmd.group.leave",
+		ValueType: "null",
+		Version:   "1",
+	}, {
+		Type:      "in",
+		MsgType:   "cmd.group.set_members",
+		ValueType: "str_array",
+		Version:   "1",
+	}, {
+		Type:      "in",
+		MsgType:   "cmd.group.get_report",
+		ValueType: "null",
+		Version:   "1",
+	}, {
+		Type:      "out",
+		MsgType:   "evt.group.report",
+		ValueType: "object",
+		Version:   "1",
 	}}
 
+	if Player.HasCapability(sonos.CapabilityHTPlayback) {
+		mediaPlayerInterfaces = append(mediaPlayerInterfaces, fimptype.Interface{
+			Type:      "in",
+			MsgType:   "cmd.hometheater.set",
+			ValueType: "bool_map",
+			Version:   "1",
+		}, fimptype.Interface{
+			Type:      "in",
+			MsgType:   "cmd.hometheater.get_report",
+			ValueType: "null",
+			Version:   "1",
+		}, fimptype.Interface{
+			Type:      "out",
+			MsgType:   "evt.hometheater.report",
+			ValueType: "bool_map",
+			Version:   "1",
+		}, fimptype.Interface{
+			Type:      "in",
+			MsgType:   "cmd.hometheater.load_tv",
+			ValueType: "null",
+			Version:   "1",
+		})
+	}
+
 	mediaPlayerService := fimptype.Service{
 		Name:    "media_player",
 		Alias:   "media_player",
@@ -135,11 +254,15 @@ func (ns *NetworkService) MakeInclusionReport(Player sonos.Player) fimptype.Thin
 		Props: map[string]interface{}{
 			"sup_playback": []string{"play", "pause", "toggle_play_pause", "next_track", "previous_track"},
 			"sup_modes":    []string{"repeat", "repeat_one", "shuffle", "crossfade"},
-			"sup_metadata": []string{"album", "track", "artist", "image_url"},
+			"sup_metadata": []string{"album", "track", "artist", "image_url", "duration_millis", "source"},
 		},
 		Interfaces: mediaPlayerInterfaces,
 	}
 
+	if Player.HasCapability(sonos.CapabilityHTPlayback) {
+		mediaPlayerService.Props["sup_hometheater"] = []string{"night_mode", "enhance_dialog"}
+	}
+
 	playerID := Player.FimpId
 	manufacturer = "sonos"
 	name = Player.Name
@@ -1,70 +1,140 @@
 package model
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/futurehomeno/edge-sonos-adapter/sonos-api"
 
-	"github.com/futurehomeno/edge-sonos-adapter/utils"
 	log "github.com/sirupsen/logrus"
 )
 
+// States is shared by the FIMP router, the event router and the poll loop. Fields may be read and written only
+// inside View and Update, other methods lock the state themselves and return copies.
 type States struct {
+	mu           sync.RWMutex
 	path         string
+	lastSaved    []byte
 	WorkDir      string `json:"-"`
 	ConfiguredAt string `json:"configuret_at"`
 	ConfiguredBy string `json:"configures_by"`
+	Version      int    `json:"version"`
 
-	Households  []sonos.Household `json:"households"`
-	Groups      []sonos.Group     `json:"groups"`
-	Players     []sonos.Player    `json:"players"`
-	Container   sonos.Container   `json:"container"`
-	CurrentItem sonos.CurrentItem `json:"currentItem"`
-	NextItem    sonos.NextItem    `json:"nextItem"`
-	StreamInfo  string            `json:"streamInfo"`
-	IsRadio     bool              `json:"is_radio"`
+	Households []sonos.Household `json:"households"`
+	// Groups and Players of all wanted households, use HouseholdGroups and HouseholdPlayers for a single household
+	Groups  []sonos.Group  `json:"groups"`
+	Players []sonos.Player `json:"players"`
+	// HouseholdStates is keyed by household id
+	HouseholdStates map[string]*HouseholdState `json:"household_states"`
 
-	PlaybackState string `json:"playbackState"`
+	// GroupStates is keyed by group id, PlayerStates by player id
+	GroupStates  map[string]*GroupState  `json:"group_states"`
+	PlayerStates map[string]*PlayerState `json:"player_states"`
+}
 
-	PlayModes struct {
-		Repeat    bool `json:"repeat"`
-		RepeatOne bool `json:"repeatOne"`
-		Shuffle   bool `json:"shuffle"`
-		Crossfade bool `json:"crossfade"`
-	}
+// GroupState is the last known state of a group. Reports are sent only when new values differ from it.
+type GroupState struct {
+	PlaybackState   string                 `json:"playback_state"` // FIMP value, play or pause
+	PositionMillis  int                    `json:"position_millis"`
+	PlayModes       PlayModes              `json:"play_modes"`
+	PlaybackActions *sonos.PlaybackActions `json:"playback_actions"`
+	Metadata        map[string]interface{} `json:"metadata"` // value of the last evt.metadata.report
+	Container       sonos.Container        `json:"container"`
+	CurrentItem     sonos.CurrentItem      `json:"current_item"`
+	NextItem        sonos.NextItem         `json:"next_item"`
+	StreamInfo      string                 `json:"stream_info"`
+	IsRadio         bool                   `json:"is_radio"`
+	Volume          int                    `json:"volume"`
+	Muted           bool                   `json:"muted"`
+	Fixed           bool                   `json:"fixed"`
+}
 
+type PlayModes struct {
+	Repeat    bool `json:"repeat"`
+	RepeatOne bool `json:"repeat_one"`
+	Shuffle   bool `json:"shuffle"`
+	Crossfade bool `json:"crossfade"`
+}
+
+// PlayerState is the last known volume of a single player
+type PlayerState struct {
 	Volume int  `json:"volume"`
 	Muted  bool `json:"muted"`
-	Fixed  bool `json:"fixed"`
+}
 
-	Favorites []sonos.Favorite
-	Playlists []sonos.Playlist
+// SetMetadata saves metadata and the report built from it
+func (gs *GroupState) SetMetadata(metadata *sonos.PlaybackMetadataResponse, report map[string]interface{}) {
+	gs.Container = metadata.Container
+	gs.CurrentItem = metadata.CurrentItem
+	gs.NextItem = metadata.NextItem
+	gs.StreamInfo = metadata.StreamInfo
+	gs.IsRadio = metadata.Container.Service.Name == "Sonos Radio"
+	gs.Metadata = report
 }
 
+// DurationMillis returns duration of the current track from the last metadata. It is read from the typed item,
+// values of the saved report are float64 after state.json is loaded.
+func (gs GroupState) DurationMillis() int64 {
+	return int64(gs.CurrentItem.Track.DurationMillis)
+}
+
+// HouseholdState holds favorites and playlists of one Sonos household
+type HouseholdState struct {
+	Favorites []sonos.Favorite `json:"favorites"`
+	Playlists []sonos.Playlist `json:"playlists"`
+}
+
+// StatesVersion is the schema version of state.json, files with other version are ignored and state is fetched again
+const StatesVersion = 3
+
 func NewStates(workDir string) *States {
 	state := &States{WorkDir: workDir}
-	//state.path = filepath.Join(workDir, "data", "state.json")
-	//if !utils.FileExists(state.path) {
-	//	log.Info("State file doesn't exist.Loading default state")
-	//	defaultStateFile := filepath.Join(workDir, "defaults", "state.json")
-	//	err := utils.CopyFile(defaultStateFile, state.path)
-	//	if err != nil {
-	//		fmt.Print(err)
-	//		panic("Can't copy state file.")
-	//	}
-	//}
+	state.path = filepath.Join(workDir, "data", "state.json")
 	return state
 }
 
+// View runs fn with read lock held. fn must not modify the state or call other methods of States which lock.
+func (st *States) View(fn func(st *States)) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	fn(st)
+}
+
+// Update runs fn with write lock held, other goroutines see all changes made by fn at once.
+// fn must not call other methods of States which lock.
+func (st *States) Update(fn func(st *States)) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	fn(st)
+}
+
+// LoadFromFile loads state saved before restart. Missing file or file with other schema version is not an error,
+// the state stays empty until it is fetched from Sonos.
 func (st *States) LoadFromFile() error {
 	stateFileBody, err := ioutil.ReadFile(st.path)
-	if err != nil {
+	if os.IsNotExist(err) {
+		log.Info("State file doesn't exist, state will be fetched from Sonos")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var version struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(stateFileBody, &version); err != nil {
 		return err
 	}
+	if version.Version != StatesVersion {
+		log.Infof("State file version %d is not supported, state will be fetched from Sonos", version.Version)
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
 	err = json.Unmarshal(stateFileBody, st)
 	if err != nil {
 		return err
@@ -72,26 +142,299 @@ func (st *States) LoadFromFile() error {
 	return nil
 }
 
+// SaveToFile writes state to a temporary file and renames it, so the state file is never left half written
 func (st *States) SaveToFile() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	_, err := st.saveToFile()
+	return err
+}
+
+func (st *States) saveToFile() ([]byte, error) {
 	st.ConfiguredBy = "auto"
 	st.ConfiguredAt = time.Now().Format(time.RFC3339)
+	st.Version = StatesVersion
+	bpayload, err := json.Marshal(st)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(st.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	tmpFile, err := ioutil.TempFile(dir, "state.json.tmp")
+	if err != nil {
+		return nil, err
+	}
+	_, err = tmpFile.Write(bpayload)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), 0664)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return nil, err
+	}
+	return bpayload, os.Rename(tmpFile.Name(), st.path)
+}
+
+// SaveIfChanged writes state only if it differs from the last saved one, so periodic polling doesn't wear the flash
+func (st *States) SaveIfChanged() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Version = StatesVersion
 	bpayload, err := json.Marshal(st)
-	err = ioutil.WriteFile(st.path, bpayload, 0664)
 	if err != nil {
 		return err
 	}
+	if bytes.Equal(bpayload, st.lastSaved) {
+		return nil
+	}
+	st.lastSaved, err = st.saveToFile()
 	return err
 }
 
+// LoadDefaults removes saved state and clears everything fetched from Sonos
 func (st *States) LoadDefaults() error {
-	stateFile := filepath.Join(st.WorkDir, "data", "state.json")
-	os.Remove(stateFile)
-	log.Info("State file doesn't exist.Loading default state")
-	defaultStateFile := filepath.Join(st.WorkDir, "defaults", "state.json")
-	return utils.CopyFile(defaultStateFile, stateFile)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	err := os.Remove(st.path)
+	st.ConfiguredAt, st.ConfiguredBy, st.Version, st.lastSaved = "", "", 0, nil
+	st.Households, st.Groups, st.Players = nil, nil, nil
+	st.HouseholdStates, st.GroupStates, st.PlayerStates = nil, nil, nil
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// GetHouseholds returns copy of households list
+func (st *States) GetHouseholds() []sonos.Household {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return append([]sonos.Household(nil), st.Households...)
+}
+
+func (st *States) SetHouseholds(households []sonos.Household) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Households = households
+}
+
+// GetGroups returns copy of groups of all households, it can be iterated while the state is updated
+func (st *States) GetGroups() []sonos.Group {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return append([]sonos.Group(nil), st.Groups...)
+}
+
+// GetPlayers returns copy of players of all households
+func (st *States) GetPlayers() []sonos.Player {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return append([]sonos.Player(nil), st.Players...)
+}
+
+func (st *States) FindGroup(groupID string) (sonos.Group, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	for _, group := range st.Groups {
+		if group.GroupId == groupID {
+			return group, true
+		}
+	}
+	return sonos.Group{}, false
+}
+
+// FindPlayer looks up player by its FIMP address
+func (st *States) FindPlayer(fimpID string) (sonos.Player, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	for _, player := range st.Players {
+		if player.FimpId == fimpID {
+			return player, true
+		}
+	}
+	return sonos.Player{}, false
+}
+
+// GetGroupState returns copy of the group state. Maps and pointers in the state are replaced on update, never modified.
+func (st *States) GetGroupState(groupID string) GroupState {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	if state, ok := st.GroupStates[groupID]; ok {
+		return *state
+	}
+	return GroupState{}
+}
+
+// GetPlayerState returns copy of the player state
+func (st *States) GetPlayerState(playerID string) PlayerState {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	if state, ok := st.PlayerStates[playerID]; ok {
+		return *state
+	}
+	return PlayerState{}
+}
+
+// GetHousehold returns copy of the household state
+func (st *States) GetHousehold(householdID string) HouseholdState {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	if household, ok := st.HouseholdStates[householdID]; ok {
+		return *household
+	}
+	return HouseholdState{}
+}
+
+// Household returns state of the household, it is created if it doesn't exist. Must be called inside Update.
+func (st *States) Household(householdID string) *HouseholdState {
+	if st.HouseholdStates == nil {
+		st.HouseholdStates = make(map[string]*HouseholdState)
+	}
+	household, ok := st.HouseholdStates[householdID]
+	if !ok {
+		household = &HouseholdState{}
+		st.HouseholdStates[householdID] = household
+	}
+	return household
+}
+
+// GroupState returns state of the group, it is created if it doesn't exist. Must be called inside Update.
+func (st *States) GroupState(groupID string) *GroupState {
+	if st.GroupStates == nil {
+		st.GroupStates = make(map[string]*GroupState)
+	}
+	state, ok := st.GroupStates[groupID]
+	if !ok {
+		state = &GroupState{}
+		st.GroupStates[groupID] = state
+	}
+	return state
+}
+
+// PlayerState returns state of the player, it is created if it doesn't exist. Must be called inside Update.
+func (st *States) PlayerState(playerID string) *PlayerState {
+	if st.PlayerStates == nil {
+		st.PlayerStates = make(map[string]*PlayerState)
+	}
+	state, ok := st.PlayerStates[playerID]
+	if !ok {
+		state = &PlayerState{}
+		st.PlayerStates[playerID] = state
+	}
+	return state
+}
+
+// SetHousehold replaces groups and players of the household. State of groups and players which still exist is kept,
+// so unchanged values are not reported again.
+func (st *States) SetHousehold(householdID string, groups []sonos.Group, players []sonos.Player) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var allGroups []sonos.Group
+	for _, group := range st.Groups {
+		if group.HouseholdId != householdID {
+			allGroups = append(allGroups, group)
+		}
+	}
+	var allPlayers []sonos.Player
+	for _, player := range st.Players {
+		if player.HouseholdId != householdID {
+			allPlayers = append(allPlayers, player)
+		}
+	}
+	st.Groups = append(allGroups, groups...)
+	st.Players = append(allPlayers, players...)
+	st.Household(householdID)
+	st.pruneStates()
+}
+
+// HouseholdGroups returns groups of one household
+func (st *States) HouseholdGroups(householdID string) []sonos.Group {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	var groups []sonos.Group
+	for _, group := range st.Groups {
+		if group.HouseholdId == householdID {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// HouseholdPlayers returns players of one household
+func (st *States) HouseholdPlayers(householdID string) []sonos.Player {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	var players []sonos.Player
+	for _, player := range st.Players {
+		if player.HouseholdId == householdID {
+			players = append(players, player)
+		}
+	}
+	return players
+}
+
+// RetainHouseholds removes groups, players and household state of households which are not in the list
+func (st *States) RetainHouseholds(householdIDs []string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	wanted := make(map[string]bool)
+	for _, id := range householdIDs {
+		wanted[id] = true
+	}
+	for id := range st.HouseholdStates {
+		if !wanted[id] {
+			delete(st.HouseholdStates, id)
+		}
+	}
+	var groups []sonos.Group
+	for _, group := range st.Groups {
+		if wanted[group.HouseholdId] {
+			groups = append(groups, group)
+		}
+	}
+	var players []sonos.Player
+	for _, player := range st.Players {
+		if wanted[player.HouseholdId] {
+			players = append(players, player)
+		}
+	}
+	st.Groups, st.Players = groups, players
+	st.pruneStates()
+}
+
+// pruneStates removes state of groups and players which don't exist anymore, group ids change with every regrouping
+func (st *States) pruneStates() {
+	for id := range st.GroupStates {
+		found := false
+		for _, group := range st.Groups {
+			found = found || group.GroupId == id
+		}
+		if !found {
+			delete(st.GroupStates, id)
+		}
+	}
+	for id := range st.PlayerStates {
+		found := false
+		for _, player := range st.Players {
+			found = found || player.Id == id
+		}
+		if !found {
+			delete(st.PlayerStates, id)
+		}
+	}
 }
 
 func (st *States) IsConfigured() bool {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
 	if len(st.Households) != 0 {
 		return true
 	}
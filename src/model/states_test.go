@@ -0,0 +1,181 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/futurehomeno/edge-sonos-adapter/sonos-api"
+)
+
+func TestStates_SaveAndLoad(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "states")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	states := NewStates(workDir)
+	states.Groups = []sonos.Group{{GroupId: "RINCON_A:1", HouseholdId: "HH_1"}}
+	states.Players = []sonos.Player{{Id: "RINCON_A", FimpId: "A", HouseholdId: "HH_1"}}
+	if err := states.SaveIfChanged(); err != nil {
+		t.Fatal("Can't save state. Err:", err)
+	}
+	files, _ := ioutil.ReadDir(filepath.Join(workDir, "data"))
+	if len(files) != 1 || files[0].Name() != "state.json" {
+		t.Fatalf("Temporary files must be removed, got %d files", len(files))
+	}
+
+	loaded := NewStates(workDir)
+	if err := loaded.LoadFromFile(); err != nil {
+		t.Fatal("Can't load state. Err:", err)
+	}
+	if len(loaded.Groups) != 1 || loaded.Players[0].FimpId != "A" || loaded.Version != StatesVersion {
+		t.Fatalf("Unexpected state %+v", loaded)
+	}
+
+	ioutil.WriteFile(filepath.Join(workDir, "data", "state.json"), []byte(`{"version":99,"groups":[{"id":"X"}]}`), 0664)
+	unsupported := NewStates(workDir)
+	if err := unsupported.LoadFromFile(); err != nil || len(unsupported.Groups) != 0 {
+		t.Fatal("State file with unknown version must be ignored")
+	}
+	if err := unsupported.LoadDefaults(); err != nil {
+		t.Fatal("Can't remove state. Err:", err)
+	}
+	if err := NewStates(workDir).LoadFromFile(); err != nil {
+		t.Fatal("Missing state file must not be an error")
+	}
+}
+
+func TestStates_Households(t *testing.T) {
+	states := NewStates("")
+	states.SetHousehold("HH_1", []sonos.Group{{GroupId: "RINCON_A:1", HouseholdId: "HH_1"}}, []sonos.Player{{Id: "RINCON_A", HouseholdId: "HH_1"}})
+	states.SetHousehold("HH_2", []sonos.Group{{GroupId: "RINCON_B:1", HouseholdId: "HH_2"}}, []sonos.Player{{Id: "RINCON_B", HouseholdId: "HH_2"}})
+	if len(states.Groups) != 2 || len(states.Players) != 2 {
+		t.Fatalf("Groups of both households must be kept, got %d groups", len(states.Groups))
+	}
+
+	states.GroupState("RINCON_A:1").Volume = 30
+	states.SetHousehold("HH_1", []sonos.Group{{GroupId: "RINCON_A:1", HouseholdId: "HH_1"}, {GroupId: "RINCON_C:1", HouseholdId: "HH_1"}}, nil)
+	groups := states.HouseholdGroups("HH_1")
+	if len(groups) != 2 || states.GroupState("RINCON_A:1").Volume != 30 {
+		t.Fatalf("Unexpected household groups %+v", groups)
+	}
+	if len(states.HouseholdPlayers("HH_2")) != 1 {
+		t.Fatal("Players of other household must not be changed")
+	}
+
+	states.Household("HH_2").Favorites = []sonos.Favorite{{ID: "1"}}
+	states.RetainHouseholds([]string{"HH_1"})
+	if len(states.HouseholdGroups("HH_2")) != 0 || states.HouseholdStates["HH_2"] != nil || len(states.Groups) != 2 {
+		t.Fatal("Household which is not wanted must be removed")
+	}
+}
+
+func TestStates_GroupState(t *testing.T) {
+	states := NewStates("")
+	states.SetHousehold("HH_1", []sonos.Group{{GroupId: "RINCON_A:1", HouseholdId: "HH_1"}, {GroupId: "RINCON_B:1", HouseholdId: "HH_1"}}, []sonos.Player{{Id: "RINCON_A", HouseholdId: "HH_1"}, {Id: "RINCON_B", HouseholdId: "HH_1"}})
+	states.GroupState("RINCON_A:1").PlaybackState = "play"
+	states.GroupState("RINCON_B:1").PlaybackState = "pause"
+	states.PlayerState("RINCON_A").Volume = 10
+	states.PlayerState("RINCON_B").Volume = 20
+	if states.GroupState("RINCON_A:1").PlaybackState != "play" || states.GroupState("RINCON_B:1").PlaybackState != "pause" {
+		t.Fatal("Groups must have separate state")
+	}
+
+	// B joins group of A
+	states.SetHousehold("HH_1", []sonos.Group{{GroupId: "RINCON_A:2", HouseholdId: "HH_1"}}, []sonos.Player{{Id: "RINCON_A", HouseholdId: "HH_1"}, {Id: "RINCON_B", HouseholdId: "HH_1"}})
+	if len(states.GroupStates) != 0 {
+		t.Fatalf("State of removed groups must be pruned, got %+v", states.GroupStates)
+	}
+	if states.PlayerState("RINCON_B").Volume != 20 {
+		t.Fatal("State of existing players must be kept")
+	}
+}
+
+func TestStates_DurationAfterReload(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "states")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	states := NewStates(workDir)
+	states.SetHousehold("HH_1", []sonos.Group{{GroupId: "RINCON_A:1", HouseholdId: "HH_1"}}, nil)
+	metadata := &sonos.PlaybackMetadataResponse{}
+	metadata.CurrentItem.Track.DurationMillis = 1500000
+	states.GroupState("RINCON_A:1").SetMetadata(metadata, map[string]interface{}{"duration_millis": 1500000})
+	if states.GetGroupState("RINCON_A:1").DurationMillis() != 1500000 {
+		t.Fatal("Unexpected duration", states.GetGroupState("RINCON_A:1").DurationMillis())
+	}
+	if err := states.SaveIfChanged(); err != nil {
+		t.Fatal("Can't save state. Err:", err)
+	}
+
+	loaded := NewStates(workDir)
+	if err := loaded.LoadFromFile(); err != nil {
+		t.Fatal("Can't load state. Err:", err)
+	}
+	if duration := loaded.GetGroupState("RINCON_A:1").DurationMillis(); duration != 1500000 {
+		t.Fatal("Duration must survive restart, got", duration)
+	}
+}
+
+// TestStates_ConcurrentAccess replaces groups while they are iterated and updated, as extended_set does during polling.
+// It is meant to be run with -race.
+func TestStates_ConcurrentAccess(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "states")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+	states := NewStates(workDir)
+	groups := []sonos.Group{{GroupId: "RINCON_A:1", HouseholdId: "HH_1"}, {GroupId: "RINCON_B:1", HouseholdId: "HH_1"}}
+	players := []sonos.Player{{Id: "RINCON_A", FimpId: "A", HouseholdId: "HH_1"}, {Id: "RINCON_B", FimpId: "B", HouseholdId: "HH_1"}}
+	states.SetHousehold("HH_1", groups, players)
+
+	var wg sync.WaitGroup
+	run := func(fn func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				fn(i)
+			}
+		}()
+	}
+	run(func(i int) {
+		if i%2 == 0 {
+			states.RetainHouseholds(nil)
+		}
+		states.SetHousehold("HH_1", groups[:1+i%2], players)
+	})
+	run(func(i int) {
+		for _, group := range states.GetGroups() {
+			states.Update(func(st *States) {
+				st.GroupState(group.GroupId).Volume = i
+			})
+			states.GetGroupState(group.GroupId)
+		}
+	})
+	run(func(i int) {
+		if player, ok := states.FindPlayer("A"); ok {
+			states.Update(func(st *States) {
+				st.PlayerState(player.Id).Muted = i%2 == 0
+			})
+		}
+		states.HouseholdGroups("HH_1")
+	})
+	run(func(i int) {
+		if err := states.SaveIfChanged(); err != nil {
+			t.Error(err)
+		}
+	})
+	wg.Wait()
+
+	if len(states.GetGroups()) != 2 || len(states.GetPlayers()) != 2 {
+		t.Fatalf("Unexpected groups %+v", states.GetGroups())
+	}
+}
@@ -0,0 +1,119 @@
+package router
+
+import (
+	"sync"
+
+	"github.com/futurehomeno/fimpgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// commandQueueSize is the max number of pending commands per queue, newer commands are rejected when the queue is full
+const commandQueueSize = 20
+
+// coalescedCommands set an absolute value, a pending command of the same type for the same address is replaced,
+// so dragging a volume slider doesn't queue a request for every step. The replaced command is answered by the replaced callback.
+var coalescedCommands = map[string]bool{"cmd.volume.set": true, "cmd.mute.set": true}
+
+// DispatcherStats is the value of evt.queue.report
+type DispatcherStats struct {
+	Queues    map[string]int `json:"queues"`    // pending commands per queue, only queues with pending commands are listed
+	Pending   int            `json:"pending"`   // pending commands in all queues
+	MaxDepth  int            `json:"max_depth"` // highest queue depth since start
+	Processed int64          `json:"processed"`
+	Coalesced int64          `json:"coalesced"`
+	Dropped   int64          `json:"dropped"`
+}
+
+// dispatcher runs commands of different queues in parallel, commands of one queue are run one by one in order.
+// Each queue has its own goroutine, which exits when the queue is empty.
+type dispatcher struct {
+	mu       sync.Mutex
+	queues   map[string][]*fimpgo.Message
+	size     int
+	handle   func(msg *fimpgo.Message)
+	replaced func(replaced, by *fimpgo.Message)
+	stats    DispatcherStats
+}
+
+func newDispatcher(size int, handle func(msg *fimpgo.Message), replaced func(replaced, by *fimpgo.Message)) *dispatcher {
+	return &dispatcher{queues: make(map[string][]*fimpgo.Message), size: size, handle: handle, replaced: replaced}
+}
+
+// Dispatch adds the message to the queue, it never blocks. Returns false if the queue is full and the message was dropped.
+func (d *dispatcher) Dispatch(key string, msg *fimpgo.Message) bool {
+	d.mu.Lock()
+	queue, running := d.queues[key]
+	var replaced *fimpgo.Message
+	if coalescedCommands[msg.Payload.Type] {
+		for i, pending := range queue {
+			if isSameCommand(pending, msg) {
+				// the newer value is moved to the end, so it keeps its order relative to other commands
+				replaced = pending
+				queue = append(queue[:i], queue[i+1:]...)
+				d.stats.Coalesced++
+				log.Debugf("<fimpr> Pending %s for %s replaced", msg.Payload.Type, msg.Addr.ServiceAddress)
+				break
+			}
+		}
+	}
+	if len(queue) >= d.size {
+		d.stats.Dropped++
+		d.mu.Unlock()
+		log.Warnf("<fimpr> Command queue %s is full, %s dropped", key, msg.Payload.Type)
+		return false
+	}
+	queue = append(queue, msg)
+	d.queues[key] = queue
+	if len(queue) > d.stats.MaxDepth {
+		d.stats.MaxDepth = len(queue)
+	}
+	if !running {
+		go d.run(key)
+	}
+	d.mu.Unlock()
+	if replaced != nil && d.replaced != nil {
+		d.replaced(replaced, msg)
+	}
+	return true
+}
+
+func (d *dispatcher) run(key string) {
+	for {
+		d.mu.Lock()
+		queue := d.queues[key]
+		if len(queue) == 0 {
+			delete(d.queues, key)
+			d.mu.Unlock()
+			return
+		}
+		msg := queue[0]
+		d.queues[key] = queue[1:]
+		d.mu.Unlock()
+
+		d.handle(msg)
+
+		d.mu.Lock()
+		d.stats.Processed++
+		d.mu.Unlock()
+	}
+}
+
+// Stats returns queue depths and counters
+func (d *dispatcher) Stats() DispatcherStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := d.stats
+	stats.Queues = make(map[string]int)
+	for key, queue := range d.queues {
+		if len(queue) > 0 {
+			stats.Queues[key] = len(queue)
+			stats.Pending += len(queue)
+		}
+	}
+	return stats
+}
+
+func isSameCommand(a, b *fimpgo.Message) bool {
+	return a.Payload.Type == b.Payload.Type && a.Payload.Service == b.Payload.Service &&
+		a.Addr.ServiceAddress == b.Addr.ServiceAddress && a.Payload.Properties["group"] == b.Payload.Properties["group"]
+}
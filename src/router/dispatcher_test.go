@@ -0,0 +1,124 @@
+package router
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/futurehomeno/fimpgo"
+)
+
+func newCommand(msgType, addr string, value int64) *fimpgo.Message {
+	return &fimpgo.Message{
+		Addr:    &fimpgo.Address{ServiceAddress: addr},
+		Payload: fimpgo.NewIntMessage(msgType, "media_player", value, nil, nil, nil),
+	}
+}
+
+// blockingHandler records handled commands, commands with value -1 block until release is closed
+type blockingHandler struct {
+	mu      sync.Mutex
+	handled []*fimpgo.Message
+	started chan string
+	release chan struct{}
+	done    sync.WaitGroup
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{started: make(chan string, 10), release: make(chan struct{})}
+}
+
+func (h *blockingHandler) handle(msg *fimpgo.Message) {
+	if value, _ := msg.Payload.GetIntValue(); value == -1 {
+		h.started <- msg.Addr.ServiceAddress
+		<-h.release
+	}
+	h.mu.Lock()
+	h.handled = append(h.handled, msg)
+	h.mu.Unlock()
+	h.done.Done()
+}
+
+func (h *blockingHandler) values(addr string) []int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var values []int64
+	for _, msg := range h.handled {
+		if msg.Addr.ServiceAddress == addr {
+			value, _ := msg.Payload.GetIntValue()
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func TestDispatcher_QueuesRunInParallel(t *testing.T) {
+	h := newBlockingHandler()
+	d := newDispatcher(10, h.handle, nil)
+	h.done.Add(4)
+	d.Dispatch("group_a", newCommand("cmd.playback.seek", "A", -1))
+	<-h.started
+	d.Dispatch("group_a", newCommand("cmd.playback.seek", "A", 1))
+	d.Dispatch("group_a", newCommand("cmd.playback.seek", "A", 2))
+	d.Dispatch("group_b", newCommand("cmd.playback.seek", "B", 3))
+
+	// group_b must not wait for the blocked command of group_a
+	deadline := time.Now().Add(time.Second)
+	for len(h.values("B")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if len(h.values("B")) != 1 || len(h.values("A")) != 0 {
+		t.Fatalf("Unexpected commands A: %v, B: %v", h.values("A"), h.values("B"))
+	}
+	if stats := d.Stats(); stats.Pending != 2 || stats.Queues["group_a"] != 2 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+
+	close(h.release)
+	h.done.Wait()
+	if values := h.values("A"); len(values) != 3 || values[0] != -1 || values[1] != 1 || values[2] != 2 {
+		t.Fatalf("Commands of one queue must keep the order, got %v", values)
+	}
+}
+
+func TestDispatcher_CoalesceAndDrop(t *testing.T) {
+	h := newBlockingHandler()
+	var replaced []int64
+	d := newDispatcher(3, h.handle, func(msg, by *fimpgo.Message) {
+		value, _ := msg.Payload.GetIntValue()
+		replaced = append(replaced, value)
+	})
+	h.done.Add(4)
+	d.Dispatch("group_a", newCommand("cmd.playback.seek", "A", -1))
+	<-h.started
+	for volume := int64(1); volume <= 10; volume++ {
+		d.Dispatch("group_a", newCommand("cmd.volume.set", "A", volume))
+	}
+	d.Dispatch("group_a", newCommand("cmd.volume.set", "B", 50))
+	d.Dispatch("group_a", newCommand("cmd.playback.seek", "A", 100))
+	if d.Dispatch("group_a", newCommand("cmd.playback.seek", "A", 200)) {
+		t.Fatal("Command must be rejected when the queue is full")
+	}
+
+	// every replaced command is answered
+	if len(replaced) != 9 || replaced[0] != 1 || replaced[8] != 9 {
+		t.Fatalf("Replaced commands must be reported, got %v", replaced)
+	}
+	stats := d.Stats()
+	if stats.Coalesced != 9 || stats.Dropped != 1 || stats.MaxDepth != 3 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+	close(h.release)
+	h.done.Wait()
+	if values := h.values("A"); len(values) != 3 || values[1] != 10 || values[2] != 100 {
+		t.Fatalf("Only the last volume must be set, got %v", values)
+	}
+	// the counter is updated after the handler returns
+	deadline := time.Now().Add(time.Second)
+	for d.Stats().Processed != 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := d.Stats(); stats.Processed != 4 || len(stats.Queues) != 0 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
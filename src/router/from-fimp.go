@@ -1,32 +1,67 @@
 package router
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/futurehomeno/edge-sonos-adapter/sonos-api"
 
+	"github.com/futurehomeno/edge-sonos-adapter/media"
 	"github.com/futurehomeno/edge-sonos-adapter/model"
 	"github.com/futurehomeno/fimpgo"
 	log "github.com/sirupsen/logrus"
 )
 
+// defaultCommandTimeout is the deadline of a command, including all requests it sends to Sonos
+const defaultCommandTimeout = 20 * time.Second
+
+// commandTimeouts are deadlines of commands which send many requests or wait for players
+var commandTimeouts = map[string]time.Duration{
+	"cmd.audioclip.play":      40 * time.Second,
+	"cmd.audioclip.say":       40 * time.Second,
+	"cmd.state.snapshot":      40 * time.Second,
+	"cmd.state.restore":       40 * time.Second,
+	"cmd.group.set_members":   40 * time.Second,
+	"cmd.app.get_manifest":    60 * time.Second,
+	"cmd.config.extended_set": 60 * time.Second,
+}
+
+// clipRestoreTimeout bounds playing a clip as a stream on players without audio clip support and restoring the group
+const clipRestoreTimeout = 5 * time.Minute
+
 type FromFimpRouter struct {
-	inboundMsgCh fimpgo.MessageCh
-	mqt          *fimpgo.MqttTransport
-	instanceId   string
-	appLifecycle *model.Lifecycle
-	configs      *model.Configs
-	states       *model.States
-	client       *sonos.Client
+	inboundMsgCh  fimpgo.MessageCh
+	mqt           *fimpgo.MqttTransport
+	instanceId    string
+	appLifecycle  *model.Lifecycle
+	configs       *model.Configs
+	states        *model.States
+	client        *sonos.Client
+	mediaServer   *media.Server
+	dispatcher    *dispatcher
+	snapshotsMux  sync.Mutex
+	snapshots     map[string]*sonos.GroupSnapshot // saved by cmd.state.snapshot, key is player address
+	groupLocksMux sync.Mutex
+	groupLocks    map[string]*groupLock // held by commands and by clips played as stream, key is group id
 }
 
-func NewFromFimpRouter(mqt *fimpgo.MqttTransport, appLifecycle *model.Lifecycle, configs *model.Configs, states *model.States, client *sonos.Client) *FromFimpRouter {
-	fc := FromFimpRouter{inboundMsgCh: make(fimpgo.MessageCh, 5), mqt: mqt, appLifecycle: appLifecycle, configs: configs, states: states, client: client}
+// groupLock is held by one command of the group at a time, it is removed when no command holds it or waits for it
+type groupLock struct {
+	ch    chan struct{}
+	users int
+}
+
+func NewFromFimpRouter(mqt *fimpgo.MqttTransport, appLifecycle *model.Lifecycle, configs *model.Configs, states *model.States, client *sonos.Client, mediaServer *media.Server) *FromFimpRouter {
+	fc := &FromFimpRouter{inboundMsgCh: make(fimpgo.MessageCh, 5), mqt: mqt, appLifecycle: appLifecycle, configs: configs, states: states, client: client, mediaServer: mediaServer, snapshots: make(map[string]*sonos.GroupSnapshot), groupLocks: make(map[string]*groupLock)}
+	fc.dispatcher = newDispatcher(commandQueueSize, fc.routeFimpMessage, fc.sendReplacedReport)
 	fc.mqt.RegisterChannel("ch1", fc.inboundMsgCh)
-	return &fc
+	return fc
 }
 
 func (fc *FromFimpRouter) Start() {
@@ -42,15 +77,45 @@ func (fc *FromFimpRouter) Start() {
 		for {
 			select {
 			case newMsg := <-msgChan:
-				fc.routeFimpMessage(newMsg)
+				if !fc.dispatcher.Dispatch(fc.queueKey(newMsg), newMsg) {
+					fc.sendQueueFullReport(newMsg)
+				}
 			}
 		}
 	}(fc.inboundMsgCh)
 }
 
+// queueKey returns the queue of the message. Commands to players of one group are run in order, groups run in parallel.
+func (fc *FromFimpRouter) queueKey(newMsg *fimpgo.Message) string {
+	if newMsg.Payload.Service != "media_player" {
+		return model.ServiceName
+	}
+	addr := strings.Replace(newMsg.Addr.ServiceAddress, "_0", "", 1)
+	if CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups()); err == nil {
+		return CorrID
+	}
+	return addr
+}
+
 func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 	log.Debug("New fimp msg . cmd = ", newMsg.Payload.Type)
+	timeout, ok := commandTimeouts[newMsg.Payload.Type]
+	if !ok {
+		timeout = defaultCommandTimeout
+	}
+	// requests are canceled when the command runs out of time or the adapter logs out, resets or terminates
+	ctx, cancel := context.WithTimeout(fc.appLifecycle.Context(), timeout)
+	defer cancel()
 	addr := strings.Replace(newMsg.Addr.ServiceAddress, "_0", "", 1)
+	if newMsg.Payload.Service == "media_player" {
+		// waits while a clip played as stream is started or restored on the group, it runs outside of the command queue
+		unlock, err := fc.lockGroup(ctx, fc.queueKey(newMsg))
+		if err != nil {
+			fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+			return
+		}
+		defer unlock()
+	}
 	ns := model.NetworkService{}
 	switch newMsg.Payload.Service {
 
@@ -66,18 +131,25 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 			prevNext := val
 
 			// find groupId from addr(playerId)
-			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.Groups)
+			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups())
 			if err != nil {
-				log.Error(err)
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			if !fc.isActionAllowed(CorrID, val) {
+				fc.sendErrorReport(addr, "ACTION_NOT_ALLOWED", fmt.Sprintf("%s is not allowed by current source", val), newMsg.Payload)
+				return
 			}
 
-			success, err := fc.client.PlaybackSet(val, CorrID)
+			success, err := fc.client.PlaybackSet(ctx, val, CorrID)
 			if err != nil {
-				log.Error(err)
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
 			}
-			pbStatus, err := fc.client.PlaybackGetStatus(CorrID)
+			pbStatus, err := fc.client.PlaybackGetStatus(ctx, CorrID)
 			if err != nil {
 				log.Error(err)
+				return
 			}
 			if pbStatus.PlaybackState == "PLAYBACK_STATE_BUFFERING" {
 				pbStatus.PlaybackState = "PLAYBACK_STATE_PLAYING"
@@ -94,65 +166,64 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 			}
 			log.Info("New playback.set, ", val)
 			if prevNext == "next_track" || prevNext == "previous_track" {
-				metadata, err := fc.client.GetMetadata(CorrID)
-				if err == nil {
-					var imageURL string
-					fc.states.Container = metadata.Container
-					fc.states.CurrentItem = metadata.CurrentItem
-					fc.states.NextItem = metadata.NextItem
-					if fc.states.Container.Service.Name == "Sonos Radio" {
-						if metadata.StreamInfo != "" {
-							fc.states.StreamInfo = metadata.StreamInfo
-						} else {
-							fc.states.StreamInfo = ""
-						}
-						fc.states.CurrentItem = sonos.CurrentItem{}
-						if metadata.Container.Name != "" {
-							fc.states.CurrentItem.Track.Artist.Name = metadata.Container.Name
-						}
-
-						imageURL = ""
-						fc.states.NextItem = sonos.NextItem{}
-						fc.states.IsRadio = true
-					} else {
-						fc.states.StreamInfo = ""
-						imageURL = fc.states.CurrentItem.Track.ImageURL
-						fc.states.IsRadio = false
-						if imageURL == "" {
-							imageURL = fc.states.Container.ImageURL
-						}
-					}
-
-					report := map[string]interface{}{
-						"album":       fc.states.CurrentItem.Track.Album.Name,
-						"track":       fc.states.CurrentItem.Track.Name,
-						"artist":      fc.states.CurrentItem.Track.Artist.Name,
-						"image_url":   imageURL,
-						"stream_info": fc.states.StreamInfo,
-						"is_radio":    fc.states.IsRadio,
-					}
-					adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
+				fc.sendMetadataReport(ctx, CorrID, addr)
+			}
 
-					msg := fimpgo.NewMessage("evt.metadata.report", "media_player", fimpgo.VTypeObject, report, nil, nil, nil)
-					if err := fc.mqt.Publish(adr, msg); err != nil {
-						log.Error(err)
-					}
-					log.Info("New metadata message sent to fimp")
+		case "cmd.playback.seek":
+			// position in milliseconds, with "relative" property the value is offset from current position
+			val, err := newMsg.Payload.GetIntValue()
+			if err != nil {
+				log.Error("<fimpr> Incompatible request message .Err:", err.Error())
+				return
+			}
+			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups())
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			if !fc.isActionAllowed(CorrID, "seek") {
+				fc.sendErrorReport(addr, "ACTION_NOT_ALLOWED", "seek is not allowed by current source", newMsg.Payload)
+				return
+			}
+			var success bool
+			if newMsg.Payload.Properties["relative"] == "true" {
+				success, err = fc.client.PlaybackSeekRelative(ctx, val, CorrID)
+			} else {
+				success, err = fc.client.PlaybackSeek(ctx, val, CorrID)
+			}
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			pbStatus, err := fc.client.PlaybackGetStatus(ctx, CorrID)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			if success {
+				duration := fc.states.GetGroupState(CorrID).DurationMillis()
+				adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
+				msg := fimpgo.NewMessage("evt.playback.report", "media_player", fimpgo.VTypeString, fc.client.SetCorrectValue(pbStatus.PlaybackState), MakePositionProps(pbStatus, duration), nil, newMsg.Payload)
+				if err := fc.mqt.Publish(adr, msg); err != nil {
+					log.Error(err)
 				}
 			}
+			log.Info("New playback position, ", val)
 
 		case "cmd.playback.get_report":
 			// find groupId from addr(playerId)
-			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.Groups)
+			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups())
 			if err != nil {
-				log.Error(err)
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
 			}
 
 			// send playback status
 
-			pbStatus, err := fc.client.PlaybackGetStatus(CorrID)
+			pbStatus, err := fc.client.PlaybackGetStatus(ctx, CorrID)
 			if err != nil {
-				log.Error(err)
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
 			}
 			if pbStatus.PlaybackState == "PLAYBACK_STATE_BUFFERING" {
 				pbStatus.PlaybackState = "PLAYBACK_STATE_PLAYING"
@@ -171,9 +242,10 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 
 		case "cmd.playbackmode.set":
 			// find groupId from addr(playerId)
-			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.Groups)
+			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups())
 			if err != nil {
-				log.Error(err)
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
 			}
 
 			// get bool_map including bool values of repeat, repeatOne, crossfade and shuffle
@@ -181,14 +253,22 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 			if err != nil {
 				log.Error("Set mode error")
 			}
+			for mode, enabled := range val {
+				if enabled && !fc.isActionAllowed(CorrID, mode) {
+					fc.sendErrorReport(addr, "ACTION_NOT_ALLOWED", fmt.Sprintf("%s is not allowed by current source", mode), newMsg.Payload)
+					return
+				}
+			}
 
-			success, err := fc.client.PlaybackModeSet(val, CorrID)
+			success, err := fc.client.PlaybackModeSet(ctx, val, CorrID)
 			if err != nil {
-				log.Error(err)
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
 			}
-			pbStatus, err := fc.client.PlaybackGetStatus(CorrID)
+			pbStatus, err := fc.client.PlaybackGetStatus(ctx, CorrID)
 			if err != nil {
 				log.Error(err)
+				return
 			}
 			if success {
 				playmodes := map[string]bool{
@@ -207,14 +287,16 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 
 		case "cmd.playbackmode.get_report":
 			// find groupId from addr(playerId)
-			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.Groups)
+			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups())
 			if err != nil {
-				log.Error(err)
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
 			}
 
-			pbStatus, err := fc.client.PlaybackGetStatus(CorrID)
+			pbStatus, err := fc.client.PlaybackGetStatus(ctx, CorrID)
 			if err != nil {
-				log.Error(err)
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
 			}
 			adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
 			msg := fimpgo.NewMessage("evt.playbackmode.report", "media_player", fimpgo.VTypeBoolMap, pbStatus.PlayModes, nil, nil, newMsg.Payload)
@@ -224,76 +306,125 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 			log.Info("cmd.playbackmode.get_report called")
 
 		case "cmd.volume.set":
-			// find groupId from addr(playerId)
-			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.Groups)
-			if err != nil {
-				log.Error(err)
-			}
-
 			// get int from 0-100 representing new volume in %
 			val, err := newMsg.Payload.GetIntValue()
 			if err != nil {
 				log.Error("Volume error", err)
+				return
+			}
+			// player address controls volume of the player, group volume is controlled with "group" property
+			isGroup := newMsg.Payload.Properties["group"] == "true"
+			var success bool
+			if isGroup {
+				var CorrID string
+				CorrID, err = fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups())
+				if err != nil {
+					fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+					return
+				}
+				success, err = fc.client.VolumeSet(ctx, val, CorrID)
+			} else {
+				success, err = fc.client.PlayerVolumeSet(ctx, val, sonos.PlayerIdFromFimpId(addr))
 			}
-
-			success, err := fc.client.VolumeSet(val, CorrID)
 			if err != nil {
-				log.Error(err)
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
 			}
-			currVolume, err := fc.client.VolumeGet(CorrID)
+			currVolume, err := fc.getVolume(ctx, addr, isGroup)
 			if err != nil {
 				log.Error(err)
 			}
-			if success {
+			if success && currVolume != nil {
 				adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
-				msg := fimpgo.NewMessage("evt.volume.report", "media_player", fimpgo.VTypeInt, currVolume.Volume, nil, nil, newMsg.Payload)
+				msg := fimpgo.NewMessage("evt.volume.report", "media_player", fimpgo.VTypeInt, currVolume.Volume, volumeProps(isGroup), nil, newMsg.Payload)
 				if err := fc.mqt.Publish(adr, msg); err != nil {
 					log.Error(err)
 				}
 			}
 			log.Info("New volume set, ", val)
 
-		case "cmd.volume.get_report":
-			// find groupId from addr(playerId)
-			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.Groups)
+		case "cmd.volume.step":
+			// positive or negative step relative to the current volume
+			val, err := newMsg.Payload.GetIntValue()
 			if err != nil {
-				log.Error(err)
+				log.Error("Volume error", err)
+				return
+			}
+			isGroup := newMsg.Payload.Properties["group"] == "true"
+			var success bool
+			if isGroup {
+				var CorrID string
+				CorrID, err = fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups())
+				if err != nil {
+					fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+					return
+				}
+				success, err = fc.client.VolumeStep(ctx, val, CorrID)
+			} else {
+				success, err = fc.client.PlayerVolumeStep(ctx, val, sonos.PlayerIdFromFimpId(addr))
+			}
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
 			}
-			currVolume, err := fc.client.VolumeGet(CorrID)
+			currVolume, err := fc.getVolume(ctx, addr, isGroup)
 			if err != nil {
 				log.Error(err)
 			}
+			if success && currVolume != nil {
+				adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
+				msg := fimpgo.NewMessage("evt.volume.report", "media_player", fimpgo.VTypeInt, currVolume.Volume, volumeProps(isGroup), nil, newMsg.Payload)
+				if err := fc.mqt.Publish(adr, msg); err != nil {
+					log.Error(err)
+				}
+			}
+			log.Info("Volume changed by ", val)
+
+		case "cmd.volume.get_report":
+			isGroup := newMsg.Payload.Properties["group"] == "true"
+			currVolume, err := fc.getVolume(ctx, addr, isGroup)
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
 			adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
-			msg := fimpgo.NewMessage("evt.volume.report", "media_player", fimpgo.VTypeInt, currVolume.Volume, nil, nil, newMsg.Payload)
+			msg := fimpgo.NewMessage("evt.volume.report", "media_player", fimpgo.VTypeInt, currVolume.Volume, volumeProps(isGroup), nil, newMsg.Payload)
 			if err := fc.mqt.Publish(adr, msg); err != nil {
 				log.Error(err)
 			}
 			log.Info("cmd.volume.get_report called")
 
 		case "cmd.mute.set":
-			// find groupId fom addr(playerId)
-			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.Groups)
-			if err != nil {
-				log.Error(err)
-			}
-
 			// get bool value
 			val, err := newMsg.Payload.GetBoolValue()
 			if err != nil {
 				log.Error("Volume error", err)
+				return
+			}
+			isGroup := newMsg.Payload.Properties["group"] == "true"
+			var success bool
+			if isGroup {
+				var CorrID string
+				CorrID, err = fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups())
+				if err != nil {
+					fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+					return
+				}
+				success, err = fc.client.VolumeMuteSet(ctx, val, CorrID)
+			} else {
+				success, err = fc.client.PlayerVolumeMuteSet(ctx, val, sonos.PlayerIdFromFimpId(addr))
 			}
-
-			success, err := fc.client.VolumeMuteSet(val, CorrID)
 			if err != nil {
-				log.Error(err)
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
 			}
-			currVolume, err := fc.client.VolumeGet(CorrID)
+			currVolume, err := fc.getVolume(ctx, addr, isGroup)
 			if err != nil {
 				log.Error(err)
 			}
-			if success {
+			if success && currVolume != nil {
 				adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
-				msg := fimpgo.NewMessage("evt.mute.report", "media_player", fimpgo.VTypeStrMap, currVolume.Muted, nil, nil, newMsg.Payload)
+				msg := fimpgo.NewMessage("evt.mute.report", "media_player", fimpgo.VTypeBool, currVolume.Muted, volumeProps(isGroup), nil, newMsg.Payload)
 				if err := fc.mqt.Publish(adr, msg); err != nil {
 					log.Error(err)
 				}
@@ -301,32 +432,33 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 			log.Info("New mute set, ", val)
 
 		case "cmd.mute.get_report":
-			// find groupId fom addr(playerId)
-			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.Groups)
+			isGroup := newMsg.Payload.Properties["group"] == "true"
+			currVolume, err := fc.getVolume(ctx, addr, isGroup)
 			if err != nil {
-				log.Error(err)
-			}
-			currVolume, err := fc.client.VolumeGet(CorrID)
-			if err != nil {
-				log.Error(err)
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
 			}
 			adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
-			msg := fimpgo.NewMessage("evt.mute.report", "media_player", fimpgo.VTypeStrMap, currVolume.Muted, nil, nil, newMsg.Payload)
+			msg := fimpgo.NewMessage("evt.mute.report", "media_player", fimpgo.VTypeBool, currVolume.Muted, volumeProps(isGroup), nil, newMsg.Payload)
 			if err := fc.mqt.Publish(adr, msg); err != nil {
 				log.Error(err)
 			}
 			log.Info("cmd.mute.get_report called")
 
 		case "cmd.favorites.get_report":
-			var err error
-			HouseholdID := fmt.Sprintf("%v", fc.configs.WantedHouseholds[0])
-			fc.states.Favorites, err = fc.client.FavoritesGet(HouseholdID)
-			// fc.states.Favorite, err = fc.fv.FavoritesGet(fc.configs.AccessToken, fc.states.Households[0].ID)
+			// favorites of the household the player belongs to
+			HouseholdID := fc.householdOf(addr)
+			favorites, err := fc.client.FavoritesGet(ctx, HouseholdID)
 			if err != nil {
 				log.Error(err)
+				favorites = fc.states.GetHousehold(HouseholdID).Favorites
+			} else {
+				fc.states.Update(func(st *model.States) {
+					st.Household(HouseholdID).Favorites = favorites
+				})
 			}
 			adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
-			msg := fimpgo.NewMessage("evt.favorites.report", "media_player", fimpgo.VTypeObject, fc.states.Favorites, nil, nil, newMsg.Payload)
+			msg := fimpgo.NewMessage("evt.favorites.report", "media_player", fimpgo.VTypeObject, favorites, fimpgo.Props{"household_id": HouseholdID}, nil, newMsg.Payload)
 			fc.mqt.Publish(adr, msg)
 			log.Info("cmd.favorites.get_report called")
 
@@ -335,69 +467,34 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 			if err != nil {
 				log.Error(err)
 			}
-			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.Groups)
+			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups())
 			if err != nil {
-				log.Error(err)
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
 			}
 			log.Debug("song id: ", val)
-			success, err := fc.client.FavoriteSet(val, CorrID)
+			success, err := fc.client.FavoriteSet(ctx, val, CorrID)
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
 			if success {
-				metadata, err := fc.client.GetMetadata(CorrID)
-				if err == nil {
-					var imageURL string
-					fc.states.Container = metadata.Container
-					fc.states.CurrentItem = metadata.CurrentItem
-					fc.states.NextItem = metadata.NextItem
-					if fc.states.Container.Service.Name == "Sonos Radio" {
-						if metadata.StreamInfo != "" {
-							fc.states.StreamInfo = metadata.StreamInfo
-						} else {
-							fc.states.StreamInfo = ""
-						}
-						fc.states.CurrentItem = sonos.CurrentItem{}
-						if metadata.Container.Name != "" {
-							fc.states.CurrentItem.Track.Artist.Name = metadata.Container.Name
-						}
-
-						imageURL = ""
-						fc.states.NextItem = sonos.NextItem{}
-						fc.states.IsRadio = true
-					} else {
-						fc.states.StreamInfo = ""
-						imageURL = fc.states.CurrentItem.Track.ImageURL
-						fc.states.IsRadio = false
-						if imageURL == "" {
-							imageURL = fc.states.Container.ImageURL
-						}
-					}
-
-					report := map[string]interface{}{
-						"album":       fc.states.CurrentItem.Track.Album.Name,
-						"track":       fc.states.CurrentItem.Track.Name,
-						"artist":      fc.states.CurrentItem.Track.Artist.Name,
-						"image_url":   imageURL,
-						"stream_info": fc.states.StreamInfo,
-						"is_radio":    fc.states.IsRadio,
-					}
-					adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
-
-					msg := fimpgo.NewMessage("evt.metadata.report", "media_player", fimpgo.VTypeObject, report, nil, nil, nil)
-					if err := fc.mqt.Publish(adr, msg); err != nil {
-						log.Error(err)
-					}
-					log.Info("New metadata message sent to fimp")
-				}
+				fc.sendMetadataReport(ctx, CorrID, addr)
 			}
 
 		case "cmd.playlists.get_report":
-			var err error
-			HouseholdID := fmt.Sprintf("%v", fc.configs.WantedHouseholds[0])
-			fc.states.Playlists, err = fc.client.PlaylistsGet(HouseholdID)
+			HouseholdID := fc.householdOf(addr)
+			playlists, err := fc.client.PlaylistsGet(ctx, HouseholdID)
 			if err != nil {
 				log.Error(err)
+				playlists = fc.states.GetHousehold(HouseholdID).Playlists
+			} else {
+				fc.states.Update(func(st *model.States) {
+					st.Household(HouseholdID).Playlists = playlists
+				})
 			}
 			adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
-			msg := fimpgo.NewMessage("evt.playlists.report", "media_player", fimpgo.VTypeObject, fc.states.Playlists, nil, nil, newMsg.Payload)
+			msg := fimpgo.NewMessage("evt.playlists.report", "media_player", fimpgo.VTypeObject, playlists, fimpgo.Props{"household_id": HouseholdID}, nil, newMsg.Payload)
 			fc.mqt.Publish(adr, msg)
 			log.Info("cmd.playlists.get_report called")
 
@@ -406,71 +503,342 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 			if err != nil {
 				log.Error(err)
 			}
-			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.Groups)
+			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups())
 			if err != nil {
-				log.Error(err)
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
 			}
 			log.Debug("playlist id: ", val)
-			success, err := fc.client.PlaylistSet(val, CorrID)
+			success, err := fc.client.PlaylistSet(ctx, val, CorrID)
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
 			if success {
-				metadata, err := fc.client.GetMetadata(CorrID)
-				if err == nil {
-					var imageURL string
-					fc.states.Container = metadata.Container
-					fc.states.CurrentItem = metadata.CurrentItem
-					fc.states.NextItem = metadata.NextItem
-					if fc.states.Container.Service.Name == "Sonos Radio" {
-						if metadata.StreamInfo != "" {
-							fc.states.StreamInfo = metadata.StreamInfo
-						} else {
-							fc.states.StreamInfo = ""
-						}
-						fc.states.CurrentItem = sonos.CurrentItem{}
-						if metadata.Container.Name != "" {
-							fc.states.CurrentItem.Track.Artist.Name = metadata.Container.Name
-						}
+				fc.sendMetadataReport(ctx, CorrID, addr)
+			}
+		case "cmd.audioclip.play":
+			// streamUrl or name of a library clip in "clip"
+			var req struct {
+				sonos.AudioClipRequest
+				Clip string `json:"clip"`
+			}
+			err := newMsg.Payload.GetObjectValue(&req)
+			if err != nil {
+				log.Error("<fimpr> Incompatible request message .Err:", err.Error())
+				return
+			}
+			if req.Clip != "" {
+				req.StreamURL, err = fc.mediaServer.ClipURL(req.Clip)
+				if err != nil {
+					fc.sendErrorReport(addr, "CLIP_NOT_FOUND", err.Error(), newMsg.Payload)
+					return
+				}
+				if req.Name == "" {
+					req.Name = req.Clip
+				}
+			}
+			fc.playAudioClip(ctx, req.AudioClipRequest, addr, newMsg.Payload)
 
-						imageURL = ""
-						fc.states.NextItem = sonos.NextItem{}
-						fc.states.IsRadio = true
-					} else {
-						fc.states.StreamInfo = ""
-						imageURL = fc.states.CurrentItem.Track.ImageURL
-						fc.states.IsRadio = false
-						if imageURL == "" {
-							imageURL = fc.states.Container.ImageURL
-						}
-					}
+		case "cmd.audioclip.say":
+			// object {"text": "", "lang": "en-US", "volume": 30}
+			var req struct {
+				Text   string `json:"text"`
+				Lang   string `json:"lang"`
+				Volume int    `json:"volume"`
+			}
+			if err := newMsg.Payload.GetObjectValue(&req); err != nil {
+				log.Error("<fimpr> Incompatible request message .Err:", err.Error())
+				return
+			}
+			url, err := fc.mediaServer.Speak(req.Text, req.Lang)
+			if err != nil {
+				log.Error("<fimpr> Can't generate speech .Err:", err.Error())
+				fc.sendErrorReport(addr, "TTS_FAILED", err.Error(), newMsg.Payload)
+				return
+			}
+			fc.playAudioClip(ctx, sonos.AudioClipRequest{Name: "TTS", StreamURL: url, Volume: req.Volume}, addr, newMsg.Payload)
 
-					report := map[string]interface{}{
-						"album":       fc.states.CurrentItem.Track.Album.Name,
-						"track":       fc.states.CurrentItem.Track.Name,
-						"artist":      fc.states.CurrentItem.Track.Artist.Name,
-						"image_url":   imageURL,
-						"stream_info": fc.states.StreamInfo,
-						"is_radio":    fc.states.IsRadio,
-					}
-					adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
+		case "cmd.audioclip.cancel":
+			// value is clip id from evt.audioclip.report
+			val, err := newMsg.Payload.GetStringValue()
+			if err != nil {
+				log.Error("<fimpr> Incompatible request message .Err:", err.Error())
+				return
+			}
+			if _, err := fc.client.AudioClipCancel(ctx, val, sonos.PlayerIdFromFimpId(addr)); err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			log.Info("Audio clip ", val, " canceled on ", addr)
 
-					msg := fimpgo.NewMessage("evt.metadata.report", "media_player", fimpgo.VTypeObject, report, nil, nil, nil)
-					if err := fc.mqt.Publish(adr, msg); err != nil {
-						log.Error(err)
-					}
-					log.Info("New metadata message sent to fimp")
+		case "cmd.playback.load_line_in":
+			// value is address of the player which line-in is played, empty value selects the addressed player
+			val, err := newMsg.Payload.GetStringValue()
+			if err != nil {
+				log.Error("<fimpr> Incompatible request message .Err:", err.Error())
+				return
+			}
+			if val == "" {
+				val = addr
+			}
+			source := fc.findPlayer(val)
+			if source == nil || !source.HasCapability(sonos.CapabilityLineIn) {
+				fc.sendErrorReport(addr, "LINE_IN_NOT_SUPPORTED", fmt.Sprintf("player %s has no line-in", val), newMsg.Payload)
+				return
+			}
+			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups())
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			if _, err := fc.client.LoadLineIn(ctx, source.Id, true, CorrID); err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			fc.sendMetadataReport(ctx, CorrID, addr)
+			log.Info("Line-in of ", val, " loaded on ", addr)
+
+		case "cmd.playback.load_url":
+			// object {"url": "", "name": "", "image_url": ""}
+			var req struct {
+				URL      string `json:"url"`
+				Name     string `json:"name"`
+				ImageURL string `json:"image_url"`
+			}
+			if err := newMsg.Payload.GetObjectValue(&req); err != nil || req.URL == "" {
+				log.Error("<fimpr> Incompatible request message, url is required")
+				return
+			}
+			CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups())
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			metadata := sonos.StationMetadata{Name: req.Name, ImageURL: req.ImageURL}
+			if _, err := fc.client.PlayStreamUrl(ctx, req.URL, metadata, CorrID); err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			fc.sendMetadataReport(ctx, CorrID, addr)
+			log.Info("Stream ", req.URL, " loaded on ", addr)
+
+		case "cmd.state.snapshot":
+			// saves members, content, position, play modes and volume of the player's group
+			group, err := fc.groupOfPlayer(addr)
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			snapshot, err := fc.client.TakeGroupSnapshot(ctx, group)
+			if err != nil {
+				log.Error("<fimpr> Can't take snapshot .Err:", err.Error())
+				fc.sendErrorReport(addr, "SNAPSHOT_FAILED", err.Error(), newMsg.Payload)
+				return
+			}
+			fc.snapshotsMux.Lock()
+			fc.snapshots[addr] = snapshot
+			fc.snapshotsMux.Unlock()
+			fc.sendSnapshotReport(addr, snapshot, newMsg.Payload)
+			log.Info("Snapshot of group ", group.GroupId, " saved")
+
+		case "cmd.state.get_report":
+			// last known state, no requests are sent to Sonos
+			player := fc.findPlayer(addr)
+			group, err := fc.groupOfPlayer(addr)
+			if player == nil || err != nil {
+				log.Error("<fimpr> Unknown player ", addr)
+				return
+			}
+			groupState, playerState := fc.states.GetGroupState(group.GroupId), fc.states.GetPlayerState(player.Id)
+			report := MakeStateReport(&group, &groupState, &playerState)
+			adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
+			msg := fimpgo.NewMessage("evt.state.report", "media_player", fimpgo.VTypeObject, report, nil, nil, newMsg.Payload)
+			if err := fc.mqt.Publish(adr, msg); err != nil {
+				log.Error(err)
+			}
+
+		case "cmd.state.restore":
+			fc.snapshotsMux.Lock()
+			snapshot, ok := fc.snapshots[addr]
+			fc.snapshotsMux.Unlock()
+			if !ok {
+				fc.sendErrorReport(addr, "NO_SNAPSHOT", "snapshot was not taken", newMsg.Payload)
+				return
+			}
+			// the coordinator might have joined another group since the snapshot was taken
+			CorrID, err := fc.client.FindGroupFromPlayer(sonos.FimpIdFromPlayerId(snapshot.CoordinatorId), fc.states.GetGroups())
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			snapshot.GroupId = CorrID
+			err = fc.client.RestoreSnapshot(ctx, snapshot)
+			fc.refreshGroups(ctx, snapshot.HouseholdId)
+			if err != nil {
+				log.Error("<fimpr> Can't restore snapshot .Err:", err.Error())
+				fc.sendErrorReport(addr, "RESTORE_FAILED", err.Error(), newMsg.Payload)
+				return
+			}
+			fc.sendMetadataReport(ctx, snapshot.GroupId, addr)
+			log.Info("Snapshot of group ", snapshot.GroupId, " restored")
+
+		case "cmd.hometheater.set":
+			// bool_map with night_mode and/or enhance_dialog
+			val, err := newMsg.Payload.GetBoolMapValue()
+			if err != nil {
+				log.Error("<fimpr> Incompatible request message .Err:", err.Error())
+				return
+			}
+			if !fc.isHomeTheater(addr, newMsg.Payload) {
+				return
+			}
+			playerID := sonos.PlayerIdFromFimpId(addr)
+			if _, err := fc.client.HomeTheaterOptionsSet(ctx, homeTheaterOptions(val), playerID); err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			fc.sendHomeTheaterReport(ctx, addr, newMsg.Payload)
+			log.Info("New home theater options set, ", val)
+
+		case "cmd.hometheater.get_report":
+			if !fc.isHomeTheater(addr, newMsg.Payload) {
+				return
+			}
+			fc.sendHomeTheaterReport(ctx, addr, newMsg.Payload)
+			log.Info("cmd.hometheater.get_report called")
+
+		case "cmd.hometheater.load_tv":
+			// null, or bool_map with options set together with switching to TV, e.g. {"night_mode": true}
+			var val map[string]bool
+			if newMsg.Payload.ValueType == fimpgo.VTypeBoolMap {
+				var err error
+				if val, err = newMsg.Payload.GetBoolMapValue(); err != nil {
+					log.Error("<fimpr> Incompatible request message .Err:", err.Error())
+					return
 				}
 			}
-		case "cmd.audioclip.play":
-			var req sonos.AudioClipRequest
-			err := newMsg.Payload.GetObjectValue(&req)
+			if !fc.isHomeTheater(addr, newMsg.Payload) {
+				return
+			}
+			playerID := sonos.PlayerIdFromFimpId(addr)
+			if _, err := fc.client.HomeTheaterLoad(ctx, playerID); err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			if len(val) > 0 {
+				if _, err := fc.client.HomeTheaterOptionsSet(ctx, homeTheaterOptions(val), playerID); err != nil {
+					fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+					return
+				}
+				fc.sendHomeTheaterReport(ctx, addr, newMsg.Payload)
+			}
+			if CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups()); err == nil {
+				fc.sendMetadataReport(ctx, CorrID, addr)
+			}
+			log.Info("Player ", addr, " switched to TV input")
+
+		case "cmd.group.join":
+			// join the group of player given in value
+			val, err := newMsg.Payload.GetStringValue()
 			if err != nil {
 				log.Error("<fimpr> Incompatible request message .Err:", err.Error())
+				return
+			}
+			player := fc.findPlayer(addr)
+			if player == nil {
+				log.Error("<fimpr> Unknown player ", addr)
+				return
+			}
+			if target := fc.findPlayer(val); target == nil || target.HouseholdId != player.HouseholdId {
+				fc.sendErrorReport(addr, "OTHER_HOUSEHOLD", fmt.Sprintf("player %s is not in the same household", val), newMsg.Payload)
+				return
 			}
-			for i := range fc.states.Players {
-				_, err = fc.client.AudioClipLoad(req.StreamURL, req.Volume, fc.states.Players[i].Id)
+			CorrID, err := fc.client.FindGroupFromPlayer(val, fc.states.GetGroups())
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			group, err := fc.client.ModifyGroupMembers(ctx, player.HouseholdId, CorrID, []string{player.Id}, nil)
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			fc.refreshGroups(ctx, player.HouseholdId)
+			fc.sendGroupReport(*group, newMsg.Payload)
+			log.Info("Player ", addr, " joined group ", group.GroupId)
+
+		case "cmd.group.leave":
+			player := fc.findPlayer(addr)
+			if player == nil {
+				log.Error("<fimpr> Unknown player ", addr)
+				return
+			}
+			group, err := fc.groupOfPlayer(addr)
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			if len(group.PlayersIds) > 1 {
+				_, err = fc.client.ModifyGroupMembers(ctx, player.HouseholdId, group.GroupId, nil, []string{player.Id})
 				if err != nil {
-					log.Error("<fimpr> Audio clip can't be played .Err:", err.Error())
+					fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+					return
+				}
+			}
+			fc.refreshGroups(ctx, player.HouseholdId)
+			// the player is now alone in its own group
+			if group, err = fc.groupOfPlayer(addr); err == nil {
+				fc.sendGroupReport(group, newMsg.Payload)
+			}
+			log.Info("Player ", addr, " left the group")
+
+		case "cmd.group.set_members":
+			// addresses of players which should be grouped together with the addressed player
+			val, err := newMsg.Payload.GetStrArrayValue()
+			if err != nil {
+				log.Error("<fimpr> Incompatible request message .Err:", err.Error())
+				return
+			}
+			player := fc.findPlayer(addr)
+			if player == nil {
+				log.Error("<fimpr> Unknown player ", addr)
+				return
+			}
+			playerIDs := []string{player.Id}
+			for _, member := range val {
+				if member != addr {
+					playerIDs = append(playerIDs, sonos.PlayerIdFromFimpId(member))
 				}
 			}
+			current, err := fc.groupOfPlayer(addr)
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			var group *sonos.Group
+			if current.CoordinatorId == player.Id {
+				group, err = fc.client.SetGroupMembers(ctx, player.HouseholdId, current.GroupId, playerIDs)
+			} else {
+				// the group of another coordinator is kept for its other members, the players form a new group
+				group, err = fc.client.CreateGroup(ctx, player.HouseholdId, playerIDs)
+			}
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			fc.refreshGroups(ctx, player.HouseholdId)
+			fc.sendGroupReport(*group, newMsg.Payload)
+			log.Info("New group members set, ", val)
+
+		case "cmd.group.get_report":
+			group, err := fc.groupOfPlayer(addr)
+			if err != nil {
+				fc.sendCommandError(ctx, addr, err, newMsg.Payload)
+				return
+			}
+			fc.sendGroupReport(group, newMsg.Payload)
+			log.Info("cmd.group.get_report called")
 
 		}
 
@@ -478,6 +846,14 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 		adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeAdapter, ResourceName: model.ServiceName, ResourceAddress: "1"}
 		switch newMsg.Payload.Type {
 
+		case "cmd.queue.get_report":
+			msg := fimpgo.NewMessage("evt.queue.report", model.ServiceName, fimpgo.VTypeObject, fc.dispatcher.Stats(), nil, nil, newMsg.Payload)
+			if err := fc.mqt.RespondToRequest(newMsg.Payload, msg); err != nil {
+				if err := fc.mqt.Publish(adr, msg); err != nil {
+					log.Error(err)
+				}
+			}
+
 		case "cmd.auth.set_tokens":
 			authReq := model.SetTokens{}
 			err := newMsg.Payload.GetObjectValue(&authReq)
@@ -491,11 +867,9 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 				ErrorCode: "",
 			}
 			if authReq.AccessToken != "" && authReq.RefreshToken != "" {
-				fc.configs.AccessToken = authReq.AccessToken
-				fc.configs.LastAuthMillis = time.Now().UnixNano() / 1000000
-				fc.configs.RefreshToken = authReq.RefreshToken
-				fc.configs.ExpiresIn = authReq.ExpiresIn
-				fc.client.SetTokens(fc.configs.AccessToken, fc.configs.RefreshToken)
+				tokens := sonos.NewTokens(authReq.AccessToken, authReq.RefreshToken, authReq.ExpiresIn)
+				fc.configs.SetTokens(tokens)
+				fc.client.Tokens().SetTokens(tokens)
 				fc.appLifecycle.SetAuthState(model.AuthStateAuthenticated)
 				fc.appLifecycle.SetConnectionState(model.ConnStateConnected)
 			} else {
@@ -509,9 +883,11 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 					log.Error(err)
 				}
 			}
-			fc.states.Households, err = fc.client.GetHousehold()
+			households, err := fc.client.GetHousehold(ctx)
 			if err != nil {
 				log.Error("error")
+			} else {
+				fc.states.SetHouseholds(households)
 			}
 			log.Info("New tokens set successfully")
 			if err := fc.configs.SaveToFile(); err != nil {
@@ -521,17 +897,19 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 		case "cmd.auth.logout":
 			// exclude all players
 			// respond to wanted topic with necessary value(s)
-			fc.configs.AccessToken = ""
-			fc.configs.WantedHouseholds = nil
-			fc.configs.LastAuthMillis = 0
+			fc.appLifecycle.CancelWork()
+			fc.configs.SetTokens(sonos.Tokens{})
+			fc.configs.Update(func(cf *model.Configs) {
+				cf.WantedHouseholds = nil
+			})
+			fc.client.Tokens().SetTokens(sonos.Tokens{})
 			fc.appLifecycle.SetConfigState(model.ConfigStateNotConfigured)
 			fc.appLifecycle.SetAuthState(model.AuthStateNotAuthenticated)
 			fc.appLifecycle.SetConnectionState(model.ConnStateDisconnected)
 
-			for i := 0; i < len(fc.states.Players); i++ {
-				playerID := fc.states.Players[i].FimpId
+			for _, player := range fc.states.GetPlayers() {
 				val := map[string]interface{}{
-					"address": playerID,
+					"address": player.FimpId,
 				}
 				adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeAdapter, ResourceName: "sonos", ResourceAddress: "1"}
 				msg := fimpgo.NewMessage("evt.thing.exclusion_report", "sonos", fimpgo.VTypeObject, val, nil, nil, newMsg.Payload)
@@ -539,14 +917,13 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 					log.Error(err)
 				}
 			}
-			fc.states.Households, fc.states.Groups, fc.states.Players = nil, nil, nil
 
 			if err := fc.configs.LoadDefaults(); err != nil {
 				log.Error(err)
 			}
-			//if err := fc.states.LoadDefaults(); err != nil {
-			//	log.Error(err)
-			//}
+			if err := fc.states.LoadDefaults(); err != nil {
+				log.Error(err)
+			}
 
 			val2 := map[string]interface{}{
 				"errors":  nil,
@@ -588,23 +965,32 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 				manifest.AppState = *fc.appLifecycle.GetAllStates()
 				manifest.ConfigState = fc.configs
 			}
-			if fc.configs.IsAuthenticated() {
+			if authErr := fc.client.Tokens().LastError(); authErr != nil {
+				// tokens are saved, but Sonos rejected them
+				manifest.AppState.Auth = model.AuthStateNotAuthenticated
+				manifest.AppState.LastErrorCode = authErr.Code
+				manifest.AppState.LastErrorText = authErr.Error()
+				manifest.Configs[0].ValT = "string"
+				manifest.Configs[0].UI.Type = "input_readonly"
+				manifest.Configs[0].Val = model.Value{Default: "Sonos login has expired, you need to login again"}
+			} else if fc.configs.IsAuthenticated() {
 				var householdSelect []interface{}
 				manifest.Configs[0].ValT = "str_map"
 				manifest.Configs[0].UI.Type = "list_checkbox"
-				for i := 0; i < len(fc.states.Households); i++ {
-					HouseholdID := fmt.Sprintf("%v", fc.states.Households[i].ID)
-					_, players, err := fc.client.GetGroupsAndPlayers(HouseholdID)
+				households := fc.states.GetHouseholds()
+				for i := 0; i < len(households); i++ {
+					HouseholdID := fmt.Sprintf("%v", households[i].ID)
+					_, players, err := fc.client.GetGroupsAndPlayers(ctx, HouseholdID)
 					if err != nil {
 						log.Error("error: ", err)
 					}
 					numPlayers := len(players)
 					if numPlayers > 1 {
 						label := fmt.Sprintf("System with %d devices", numPlayers)
-						householdSelect = append(householdSelect, map[string]interface{}{"val": fc.states.Households[i].ID, "label": map[string]interface{}{"en": label}})
+						householdSelect = append(householdSelect, map[string]interface{}{"val": households[i].ID, "label": map[string]interface{}{"en": label}})
 					} else {
 						label := fmt.Sprintf("System with %d device", numPlayers)
-						householdSelect = append(householdSelect, map[string]interface{}{"val": fc.states.Households[i].ID, "label": map[string]interface{}{"en": label}})
+						householdSelect = append(householdSelect, map[string]interface{}{"val": households[i].ID, "label": map[string]interface{}{"en": label}})
 					}
 				}
 				manifest.Configs[0].UI.Select = householdSelect
@@ -650,11 +1036,20 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 				log.Error("Can't parse configuration object")
 				return
 			}
-			fc.configs.WantedHouseholds = conf.WantedHouseholds
+			var localApiKey string
+			fc.configs.Update(func(cf *model.Configs) {
+				cf.WantedHouseholds = conf.WantedHouseholds
+				cf.LocalHouseholds = conf.LocalHouseholds
+				if conf.LocalApiKey != "" {
+					cf.LocalApiKey = conf.LocalApiKey
+				}
+				localApiKey = cf.LocalApiKey
+			})
+			fc.client.EnableLocalControl(localApiKey, conf.LocalHouseholds)
 			if err := fc.configs.SaveToFile(); err != nil {
 				log.Error(err)
 			}
-			log.Debugf("App reconfigured . New parameters : %v", fc.configs)
+			log.Debugf("App reconfigured . New households : %v , local households : %v", conf.WantedHouseholds, conf.LocalHouseholds)
 			configReport := model.ConfigReport{
 				OpStatus: "ok",
 				AppState: *fc.appLifecycle.GetAllStates(),
@@ -666,28 +1061,30 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 				}
 			}
 
-			for i := 0; i < len(fc.configs.WantedHouseholds); i++ {
-				HouseholdID := fmt.Sprintf("%v", fc.configs.WantedHouseholds[i])
-				fc.states.Groups, fc.states.Players, err = fc.client.GetGroupsAndPlayers(HouseholdID)
+			wantedHouseholds := fc.configs.GetWantedHouseholds()
+			fc.states.RetainHouseholds(wantedHouseholds)
+			for _, HouseholdID := range wantedHouseholds {
+				groups, players, err := fc.client.GetGroupsAndPlayers(ctx, HouseholdID)
 
 				if err != nil {
 					log.Error("<fimpr> Can't get groups and player . Err:", err.Error())
 					//TODO : Report error here
 				} else {
+					fc.states.SetHousehold(HouseholdID, groups, players)
 
-					for i := 0; i < len(fc.states.Players); i++ {
-						inclReport := ns.MakeInclusionReport(fc.states.Players[i])
+					for i := 0; i < len(players); i++ {
+						inclReport := ns.MakeInclusionReport(players[i])
 
 						msg := fimpgo.NewMessage("evt.thing.inclusion_report", "sonos", fimpgo.VTypeObject, inclReport, nil, nil, nil)
 						adr := fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeAdapter, ResourceName: "sonos", ResourceAddress: "1"}
 						if err := fc.mqt.Publish(&adr, msg); err != nil {
 							log.Error(err)
 						}
-						CorrID, err := fc.client.FindGroupFromPlayer(inclReport.DeviceId, fc.states.Groups)
+						CorrID, err := fc.client.FindGroupFromPlayer(inclReport.DeviceId, fc.states.GetGroups())
 						if err != nil {
 							log.Error(err)
 						}
-						pbStatus, err := fc.client.PlaybackGetStatus(CorrID)
+						pbStatus, err := fc.client.PlaybackGetStatus(ctx, CorrID)
 						if err != nil {
 							log.Error(err)
 						}
@@ -709,7 +1106,7 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 					fc.appLifecycle.SetConfigState(model.ConfigStateConfigured)
 				}
 			}
-			log.Info("Wanted households updated, new wanted households: ", fc.configs.WantedHouseholds)
+			log.Info("Wanted households updated, new wanted households: ", wantedHouseholds)
 
 		case "cmd.log.set_level":
 			// Configure log level
@@ -720,7 +1117,9 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 			logLevel, err := log.ParseLevel(level)
 			if err == nil {
 				log.SetLevel(logLevel)
-				fc.configs.LogLevel = level
+				fc.configs.Update(func(cf *model.Configs) {
+					cf.LogLevel = level
+				})
 				if err := fc.configs.SaveToFile(); err != nil {
 					log.Error(err)
 				}
@@ -753,11 +1152,20 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 				ErrorCode:       "",
 				ErrorText:       "",
 			}
+			fc.appLifecycle.CancelWork()
 			fc.appLifecycle.SetConfigState(model.ConfigStateNotConfigured)
 			fc.appLifecycle.SetAppState(model.AppStateNotConfigured, nil)
 			fc.appLifecycle.SetAuthState(model.AuthStateNotAuthenticated)
+			fc.client.Tokens().SetTokens(sonos.Tokens{})
+			// configs are saved below, tokens in memory must not be written back
+			fc.configs.SetTokens(sonos.Tokens{})
+			fc.configs.Update(func(cf *model.Configs) {
+				cf.WantedHouseholds = nil
+			})
 			fc.configs.LoadDefaults()
-			//fc.states.LoadDefaults()
+			if err := fc.states.LoadDefaults(); err != nil {
+				log.Error(err)
+			}
 			msg := fimpgo.NewMessage("evt.app.config_action_report", model.ServiceName, fimpgo.VTypeObject, val, nil, nil, newMsg.Payload)
 			if err := fc.mqt.RespondToRequest(newMsg.Payload, msg); err != nil {
 				if err := fc.mqt.Publish(adr, msg); err != nil {
@@ -768,20 +1176,60 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 				log.Error(err)
 			}
 
+		case "cmd.clip.upload":
+			// object {"name": "doorbell.mp3", "data": "<base64>"} or {"name": "doorbell.mp3", "url": "https://..."}
+			var req struct {
+				Name string `json:"name"`
+				Data string `json:"data"`
+				URL  string `json:"url"`
+			}
+			if err := newMsg.Payload.GetObjectValue(&req); err != nil {
+				log.Error("<fimpr> Incompatible request message .Err:", err.Error())
+				return
+			}
+			var err error
+			if req.URL != "" {
+				err = fc.mediaServer.DownloadClip(req.Name, req.URL)
+			} else {
+				var data []byte
+				data, err = base64.StdEncoding.DecodeString(req.Data)
+				if err == nil {
+					err = fc.mediaServer.SaveClip(req.Name, data)
+				}
+			}
+			fc.sendClipListReport(err, newMsg.Payload)
+
+		case "cmd.clip.get_list":
+			fc.sendClipListReport(nil, newMsg.Payload)
+
+		case "cmd.clip.rename":
+			// str_map {"name": "old.mp3", "new_name": "new.mp3"}
+			val, err := newMsg.Payload.GetStrMapValue()
+			if err != nil {
+				log.Error("<fimpr> Incompatible request message .Err:", err.Error())
+				return
+			}
+			fc.sendClipListReport(fc.mediaServer.RenameClip(val["name"], val["new_name"]), newMsg.Payload)
+
+		case "cmd.clip.delete":
+			val, err := newMsg.Payload.GetStringValue()
+			if err != nil {
+				log.Error("<fimpr> Incompatible request message .Err:", err.Error())
+				return
+			}
+			fc.sendClipListReport(fc.mediaServer.DeleteClip(val), newMsg.Payload)
+
 		case "cmd.network.get_all_nodes":
 			// TODO: This is an example . Add your logic here or remove
 		case "cmd.thing.get_inclusion_report":
 			nodeId, _ := newMsg.Payload.GetStringValue()
-			for i := 0; i < len(fc.states.Players); i++ {
-				if nodeId == fc.states.Players[i].FimpId {
-					Player := fc.states.Players[i]
-					inclReport := ns.MakeInclusionReport(Player)
-
-					msg := fimpgo.NewMessage("evt.thing.inclusion_report", "sonos", fimpgo.VTypeObject, inclReport, nil, nil, nil)
-					adr := fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeAdapter, ResourceName: "sonos", ResourceAddress: "1"}
-					if err := fc.mqt.Publish(&adr, msg); err != nil {
-						log.Error(err)
-					}
+			if Player, ok := fc.states.FindPlayer(nodeId); ok {
+				inclReport := ns.MakeInclusionReport(Player)
+
+				msg := fimpgo.NewMessage("evt.thing.inclusion_report", "sonos", fimpgo.VTypeObject, inclReport, nil, nil, nil)
+				adr := fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeAdapter, ResourceName: "sonos", ResourceAddress: "1"}
+				if err := fc.mqt.Publish(&adr, msg); err != nil {
+					log.Error(err)
 				}
 			}
 		case "cmd.thing.inclusion":
@@ -810,10 +1258,9 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 
 			}
 		case "cmd.app.uninstall":
-			for i := 0; i < len(fc.states.Players); i++ {
-				playerID := fc.states.Players[i].FimpId
+			for _, player := range fc.states.GetPlayers() {
 				val := map[string]interface{}{
-					"address": playerID,
+					"address": player.FimpId,
 				}
 				adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeAdapter, ResourceName: "sonos", ResourceAddress: "1"}
 				msg := fimpgo.NewMessage("evt.thing.exclusion_report", "sonos", fimpgo.VTypeObject, val, nil, nil, newMsg.Payload)
@@ -823,10 +1270,384 @@ func (fc *FromFimpRouter) routeFimpMessage(newMsg *fimpgo.Message) {
 			}
 		}
 
-		//if err := fc.states.SaveToFile(); err != nil {
-		//	log.Error(err)
-		//}
+		if err := fc.states.SaveIfChanged(); err != nil {
+			log.Error(err)
+		}
+
+	}
+
+}
+
+func (fc *FromFimpRouter) sendSnapshotReport(addr string, snapshot *sonos.GroupSnapshot, request *fimpgo.FimpMessage) {
+	members := []string{}
+	for _, id := range snapshot.PlayerIds {
+		members = append(members, sonos.FimpIdFromPlayerId(id))
+	}
+	report := map[string]interface{}{
+		"members":         members,
+		"playback_state":  fc.client.SetCorrectValue(snapshot.PlaybackState),
+		"position_millis": snapshot.PositionMillis,
+		"modes":           snapshot.PlayModes,
+		"volume":          snapshot.Volume,
+		"muted":           snapshot.Muted,
+		"container":       snapshot.Container.Name,
+		"restorable":      snapshot.IsRestorable(),
+	}
+	adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
+	msg := fimpgo.NewMessage("evt.state.snapshot_report", "media_player", fimpgo.VTypeObject, report, nil, nil, request)
+	if err := fc.mqt.Publish(adr, msg); err != nil {
+		log.Error(err)
+	}
+}
+
+// sendClipListReport publishes evt.clip.list_report, or evt.error.report if the library operation has failed
+func (fc *FromFimpRouter) sendClipListReport(opErr error, request *fimpgo.FimpMessage) {
+	adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeAdapter, ResourceName: model.ServiceName, ResourceAddress: "1"}
+	var msg *fimpgo.FimpMessage
+	clips, err := fc.mediaServer.ListClips()
+	if opErr != nil || err != nil {
+		if opErr == nil {
+			opErr = err
+		}
+		log.Error("<fimpr> Clip library operation failed .Err:", opErr.Error())
+		msg = fimpgo.NewMessage("evt.error.report", model.ServiceName, fimpgo.VTypeString, opErr.Error(), fimpgo.Props{"code": "CLIP_LIBRARY_ERROR"}, nil, request)
+	} else {
+		msg = fimpgo.NewMessage("evt.clip.list_report", model.ServiceName, fimpgo.VTypeObject, clips, nil, nil, request)
+	}
+	if err := fc.mqt.RespondToRequest(request, msg); err != nil {
+		if err := fc.mqt.Publish(adr, msg); err != nil {
+			log.Error(err)
+		}
+	}
+}
 
+// playAudioClip plays the clip on the addressed player, property all_players=true plays it on every player
+func (fc *FromFimpRouter) playAudioClip(ctx context.Context, req sonos.AudioClipRequest, addr string, request *fimpgo.FimpMessage) {
+	targets := []string{addr}
+	if request.Properties["all_players"] == "true" {
+		targets = nil
+		for _, player := range fc.states.GetPlayers() {
+			targets = append(targets, player.FimpId)
+		}
+	}
+	fallbackGroups := map[string]bool{}
+	for _, target := range targets {
+		if player := fc.findPlayer(target); player != nil && !player.HasCapability(sonos.CapabilityAudioClip) {
+			// older players can't play clips, the clip is played as a stream on the player's group
+			CorrID, err := fc.client.FindGroupFromPlayer(target, fc.states.GetGroups())
+			if err != nil || fallbackGroups[CorrID] {
+				continue
+			}
+			fallbackGroups[CorrID] = true
+			go fc.playClipWithRestore(req, player.Id, CorrID, player.HouseholdId, target, request)
+			continue
+		}
+		clip, err := fc.client.AudioClipPlay(ctx, req, sonos.PlayerIdFromFimpId(target))
+		if err != nil {
+			fc.sendCommandError(ctx, target, err, request)
+			continue
+		}
+		fc.sendAudioClipReport(target, clip, request)
 	}
+}
 
+// playClipWithRestore plays the clip as a stream and restores the group. It holds the group lock while the group is saved
+// and while it is restored, other commands of the group run while the clip plays, e.g. pause ends the clip early.
+func (fc *FromFimpRouter) playClipWithRestore(req sonos.AudioClipRequest, playerID, groupID, householdID, addr string, request *fimpgo.FimpMessage) {
+	log.Info("<fimpr> Player ", addr, " has no audio clip support, playing clip as stream")
+	// the clip outlives the command, so it has its own deadline
+	ctx, cancel := context.WithTimeout(fc.appLifecycle.Context(), clipRestoreTimeout)
+	defer cancel()
+	unlock, err := fc.lockGroup(ctx, groupID)
+	if err != nil {
+		fc.sendCommandError(ctx, addr, err, request)
+		return
+	}
+	stream, clip, err := fc.client.PlayClipAsStream(ctx, req, playerID, groupID, householdID)
+	unlock()
+	if err != nil {
+		log.Error("<fimpr> Audio clip can't be played .Err:", err.Error())
+		fc.sendErrorReport(addr, "AUDIO_CLIP_FAILED", err.Error(), request)
+		return
+	}
+	if clip != nil {
+		// content of the group can't be restored, the player played the clip with the audio clip API
+		fc.sendAudioClipReport(addr, clip, request)
+		return
+	}
+	stream.WaitForEnd(ctx)
+	if unlock, err = fc.lockGroup(ctx, groupID); err != nil {
+		fc.sendCommandError(ctx, addr, err, request)
+		return
+	}
+	defer unlock()
+	if err := stream.Restore(ctx); err != nil {
+		log.Error("<fimpr> Group can't be restored after audio clip .Err:", err.Error())
+		fc.sendErrorReport(addr, "AUDIO_CLIP_FAILED", err.Error(), request)
+	}
+}
+
+func (fc *FromFimpRouter) sendAudioClipReport(addr string, clip *sonos.AudioClip, request *fimpgo.FimpMessage) {
+	report := map[string]string{
+		"id":        clip.ID,
+		"name":      clip.Name,
+		"clip_type": clip.ClipType,
+		"priority":  clip.Priority,
+	}
+	adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
+	msg := fimpgo.NewMessage("evt.audioclip.report", "media_player", fimpgo.VTypeStrMap, report, nil, nil, request)
+	if err := fc.mqt.Publish(adr, msg); err != nil {
+		log.Error(err)
+	}
+}
+
+func (fc *FromFimpRouter) sendHomeTheaterReport(ctx context.Context, addr string, request *fimpgo.FimpMessage) {
+	options, err := fc.client.HomeTheaterOptionsGet(ctx, sonos.PlayerIdFromFimpId(addr))
+	if err != nil {
+		fc.sendCommandError(ctx, addr, err, request)
+		return
+	}
+	report := map[string]bool{}
+	if options.NightMode != nil {
+		report["night_mode"] = *options.NightMode
+	}
+	if options.EnhanceDialog != nil {
+		report["enhance_dialog"] = *options.EnhanceDialog
+	}
+	adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
+	msg := fimpgo.NewMessage("evt.hometheater.report", "media_player", fimpgo.VTypeBoolMap, report, nil, nil, request)
+	if err := fc.mqt.Publish(adr, msg); err != nil {
+		log.Error(err)
+	}
+}
+
+// isHomeTheater checks HT_PLAYBACK capability of the player, players without it are answered with evt.error.report
+func (fc *FromFimpRouter) isHomeTheater(addr string, request *fimpgo.FimpMessage) bool {
+	if player := fc.findPlayer(addr); player == nil || !player.HasCapability(sonos.CapabilityHTPlayback) {
+		fc.sendErrorReport(addr, "NOT_SUPPORTED", fmt.Sprintf("player %s has no home theater capability", addr), request)
+		return false
+	}
+	return true
+}
+
+// homeTheaterOptions converts bool_map with night_mode and/or enhance_dialog, missing options are not changed
+func homeTheaterOptions(val map[string]bool) sonos.HomeTheaterOptions {
+	var options sonos.HomeTheaterOptions
+	if nightMode, ok := val["night_mode"]; ok {
+		options.NightMode = &nightMode
+	}
+	if enhanceDialog, ok := val["enhance_dialog"]; ok {
+		options.EnhanceDialog = &enhanceDialog
+	}
+	return options
+}
+
+// getVolume returns volume of the player or, if isGroup is set, volume of the group the player belongs to
+func (fc *FromFimpRouter) getVolume(ctx context.Context, addr string, isGroup bool) (*sonos.VolumeResponse, error) {
+	if !isGroup {
+		return fc.client.PlayerVolumeGet(ctx, sonos.PlayerIdFromFimpId(addr))
+	}
+	CorrID, err := fc.client.FindGroupFromPlayer(addr, fc.states.GetGroups())
+	if err != nil {
+		return nil, err
+	}
+	return fc.client.VolumeGet(ctx, CorrID)
+}
+
+func volumeProps(isGroup bool) fimpgo.Props {
+	if isGroup {
+		return fimpgo.Props{"group": "true"}
+	}
+	return nil
+}
+
+// sendMetadataReport fetches metadata of the group and publishes evt.metadata.report to the player address
+func (fc *FromFimpRouter) sendMetadataReport(ctx context.Context, groupID, addr string) {
+	metadata, err := fc.client.GetMetadata(ctx, groupID)
+	if err != nil {
+		log.Error("<fimpr> Can't get metadata. Err:", err)
+		return
+	}
+	report := MakeMetadataReport(metadata)
+	fc.states.Update(func(st *model.States) {
+		st.GroupState(groupID).SetMetadata(metadata, report)
+	})
+
+	adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
+	msg := fimpgo.NewMessage("evt.metadata.report", "media_player", fimpgo.VTypeObject, report, nil, nil, nil)
+	if err := fc.mqt.Publish(adr, msg); err != nil {
+		log.Error(err)
+	}
+	log.Info("New metadata message sent to fimp")
+}
+
+// isActionAllowed checks last known playback capabilities of the group. Actions are allowed until capabilities are known.
+func (fc *FromFimpRouter) isActionAllowed(groupID, action string) bool {
+	actions := fc.states.GetGroupState(groupID).PlaybackActions
+	if actions == nil {
+		return true
+	}
+	allowed, ok := MakeCapabilitiesReport(*actions)[action]
+	return !ok || allowed
+}
+
+// sendErrorReport responds to a media_player command with evt.error.report
+func (fc *FromFimpRouter) sendErrorReport(addr, code, text string, request *fimpgo.FimpMessage) {
+	adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: addr}
+	msg := fimpgo.NewMessage("evt.error.report", "media_player", fimpgo.VTypeString, text, fimpgo.Props{"code": code}, nil, request)
+	if err := fc.mqt.Publish(adr, msg); err != nil {
+		log.Error(err)
+	}
+	log.Info("<fimpr> Command rejected: ", text)
+}
+
+// sendQueueFullReport responds with evt.error.report to a command which was dropped because its queue is full
+func (fc *FromFimpRouter) sendQueueFullReport(newMsg *fimpgo.Message) {
+	text := fmt.Sprintf("too many pending commands, %s dropped", newMsg.Payload.Type)
+	if newMsg.Payload.Service == "media_player" {
+		fc.sendErrorReport(strings.Replace(newMsg.Addr.ServiceAddress, "_0", "", 1), "QUEUE_FULL", text, newMsg.Payload)
+		return
+	}
+	adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeAdapter, ResourceName: model.ServiceName, ResourceAddress: "1"}
+	msg := fimpgo.NewMessage("evt.error.report", model.ServiceName, fimpgo.VTypeString, text, fimpgo.Props{"code": "QUEUE_FULL"}, nil, newMsg.Payload)
+	if err := fc.mqt.RespondToRequest(newMsg.Payload, msg); err != nil {
+		if err := fc.mqt.Publish(adr, msg); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// sendReplacedReport responds with evt.error.report to a pending command which was replaced by a newer one, it was not executed
+func (fc *FromFimpRouter) sendReplacedReport(replaced, by *fimpgo.Message) {
+	text := fmt.Sprintf("replaced by newer %s", by.Payload.Type)
+	fc.sendErrorReport(strings.Replace(replaced.Addr.ServiceAddress, "_0", "", 1), "REPLACED", text, replaced.Payload)
+}
+
+// sendCommandError responds with evt.error.report when the command couldn't be sent to Sonos or was rejected by Sonos.
+// Groups and players are reloaded if the group is gone, so the next command is routed to the current group.
+func (fc *FromFimpRouter) sendCommandError(ctx context.Context, addr string, err error, request *fimpgo.FimpMessage) {
+	log.Error("<fimpr> Command ", request.Type, " failed .Err:", err.Error())
+	fc.sendErrorReport(addr, errorCode(err), err.Error(), request)
+	if errors.Is(err, sonos.ErrNotFound) {
+		fc.refreshGroups(ctx, fc.householdOf(addr))
+	}
+}
+
+// errorCode maps client errors to codes of evt.error.report
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, sonos.ErrUnauthorized):
+		return "AUTH_FAILED"
+	case errors.Is(err, sonos.ErrNotFound):
+		return "GROUP_NOT_FOUND"
+	case errors.Is(err, sonos.ErrRateLimited):
+		return "RATE_LIMITED"
+	case errors.Is(err, sonos.ErrPlayerOffline):
+		return "PLAYER_OFFLINE"
+	case errors.Is(err, sonos.ErrBadRequest):
+		return "BAD_REQUEST"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "TIMEOUT"
+	case errors.Is(err, context.Canceled):
+		return "CANCELED"
+	}
+	return "REQUEST_FAILED"
+}
+
+// householdOf returns household of the player, or the first wanted household if the player is unknown
+func (fc *FromFimpRouter) householdOf(fimpID string) string {
+	if player := fc.findPlayer(fimpID); player != nil && player.HouseholdId != "" {
+		return player.HouseholdId
+	}
+	if wantedHouseholds := fc.configs.GetWantedHouseholds(); len(wantedHouseholds) > 0 {
+		return wantedHouseholds[0]
+	}
+	return ""
+}
+
+// findPlayer returns copy of the player, or nil if the player is unknown
+func (fc *FromFimpRouter) findPlayer(fimpID string) *sonos.Player {
+	if player, ok := fc.states.FindPlayer(fimpID); ok {
+		return &player
+	}
+	return nil
+}
+
+// lockGroup waits until no other command or clip runs on the group, or ctx is done. The returned function releases the lock.
+func (fc *FromFimpRouter) lockGroup(ctx context.Context, groupID string) (func(), error) {
+	fc.groupLocksMux.Lock()
+	lock, ok := fc.groupLocks[groupID]
+	if !ok {
+		lock = &groupLock{ch: make(chan struct{}, 1)}
+		fc.groupLocks[groupID] = lock
+	}
+	lock.users++
+	fc.groupLocksMux.Unlock()
+	// locks of groups which are gone after regrouping don't pile up
+	release := func() {
+		fc.groupLocksMux.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(fc.groupLocks, groupID)
+		}
+		fc.groupLocksMux.Unlock()
+	}
+	select {
+	case lock.ch <- struct{}{}:
+		return func() {
+			<-lock.ch
+			release()
+		}, nil
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
+
+// groupOfPlayer returns copy of the group the player belongs to. The group is looked up in one copy of the groups,
+// so it can't disappear between finding the group id and reading the group.
+func (fc *FromFimpRouter) groupOfPlayer(addr string) (sonos.Group, error) {
+	groups := fc.states.GetGroups()
+	groupID, err := fc.client.FindGroupFromPlayer(addr, groups)
+	if err != nil {
+		return sonos.Group{}, err
+	}
+	for _, group := range groups {
+		if group.GroupId == groupID {
+			return group, nil
+		}
+	}
+	return sonos.Group{}, fmt.Errorf("%w: group %s", sonos.ErrNotFound, groupID)
+}
+
+// refreshGroups reloads groups and players of the household after group topology was changed
+func (fc *FromFimpRouter) refreshGroups(ctx context.Context, householdID string) {
+	groups, players, err := fc.client.GetGroupsAndPlayers(ctx, householdID)
+	if err != nil {
+		log.Error("<fimpr> Can't get groups and player . Err:", err.Error())
+		return
+	}
+	fc.states.SetHousehold(householdID, groups, players)
+	if err := fc.states.SaveIfChanged(); err != nil {
+		log.Error(err)
+	}
+}
+
+// sendGroupReport publishes evt.group.report to every member of the group
+func (fc *FromFimpRouter) sendGroupReport(group sonos.Group, request *fimpgo.FimpMessage) {
+	var members []string
+	for _, id := range group.PlayersIds {
+		members = append(members, sonos.FimpIdFromPlayerId(fmt.Sprintf("%v", id)))
+	}
+	report := map[string]interface{}{
+		"coordinator": sonos.FimpIdFromPlayerId(group.CoordinatorId),
+		"members":     members,
+	}
+	for _, member := range members {
+		adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: member}
+		msg := fimpgo.NewMessage("evt.group.report", "media_player", fimpgo.VTypeObject, report, nil, nil, request)
+		if err := fc.mqt.Publish(adr, msg); err != nil {
+			log.Error(err)
+		}
+	}
 }
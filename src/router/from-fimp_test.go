@@ -0,0 +1,121 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/futurehomeno/edge-sonos-adapter/model"
+	"github.com/futurehomeno/edge-sonos-adapter/sonos-api"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := map[error]string{
+		fmt.Errorf("%w: no group contains player A", sonos.ErrNotFound):      "GROUP_NOT_FOUND",
+		&sonos.AuthError{Code: sonos.AuthErrorTokenRevoked, Permanent: true}: "AUTH_FAILED",
+		sonos.ErrRateLimited:                                   "RATE_LIMITED",
+		fmt.Errorf("dial tcp: i/o timeout"):                    "REQUEST_FAILED",
+		fmt.Errorf("Get volume: %w", context.DeadlineExceeded): "TIMEOUT",
+		context.Canceled:                                       "CANCELED",
+	}
+	for err, code := range tests {
+		if errorCode(err) != code {
+			t.Errorf("%v mapped to %s, expected %s", err, errorCode(err), code)
+		}
+	}
+}
+
+func TestFromFimpRouter_GroupOfPlayer(t *testing.T) {
+	fc := &FromFimpRouter{states: model.NewStates(""), client: sonos.NewClient("beta", "", "")}
+	groups := []sonos.Group{{GroupId: "RINCON_A:1", HouseholdId: "HH_1", PlayersIds: []interface{}{"RINCON_A", "RINCON_B"}}}
+	fc.states.SetHousehold("HH_1", groups, nil)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		// the poll loop replaces groups while commands run
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			fc.states.SetHousehold("HH_1", nil, nil)
+			fc.states.SetHousehold("HH_1", groups, nil)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if group, err := fc.groupOfPlayer("B"); err == nil && group.GroupId != "RINCON_A:1" {
+			t.Fatalf("Unexpected group %+v", group)
+		} else if err != nil && !errors.Is(err, sonos.ErrNotFound) {
+			t.Fatal("Missing group must be not found error, got", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestFromFimpRouter_LockGroup(t *testing.T) {
+	fc := &FromFimpRouter{groupLocks: make(map[string]*groupLock)}
+	unlock, err := fc.lockGroup(context.Background(), "RINCON_A:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := fc.lockGroup(ctx, "RINCON_A:1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Command must wait for the clip playing on the group, got", err)
+	}
+	if unlockB, err := fc.lockGroup(context.Background(), "RINCON_B:1"); err != nil {
+		t.Fatal("Other groups must not wait, err:", err)
+	} else {
+		unlockB()
+	}
+	unlock()
+	unlock, err = fc.lockGroup(context.Background(), "RINCON_A:1")
+	if err != nil {
+		t.Fatal("Group must be free after unlock, err:", err)
+	}
+	unlock()
+	if len(fc.groupLocks) != 0 {
+		t.Fatalf("Unused locks must be removed, got %v", fc.groupLocks)
+	}
+}
+
+func TestHomeTheaterOptions(t *testing.T) {
+	options := homeTheaterOptions(map[string]bool{"night_mode": true})
+	if options.NightMode == nil || !*options.NightMode || options.EnhanceDialog != nil {
+		t.Errorf("Only night mode must be set, got %+v", options)
+	}
+	options = homeTheaterOptions(map[string]bool{"night_mode": false, "enhance_dialog": true})
+	if options.NightMode == nil || *options.NightMode || options.EnhanceDialog == nil || !*options.EnhanceDialog {
+		t.Errorf("Both options must be set, got %+v", options)
+	}
+}
+
+func TestFromFimpRouter_IsActionAllowed(t *testing.T) {
+	fc := &FromFimpRouter{states: model.NewStates("")}
+	fc.states.Update(func(st *model.States) {
+		st.GroupState("RADIO:1").PlaybackActions = &sonos.PlaybackActions{CanCrossfade: true}
+		st.GroupState("TRACK:1").PlaybackActions = &sonos.PlaybackActions{CanSkip: true, CanSkipBack: true, CanSeek: true}
+	})
+	tests := []struct {
+		groupID string
+		action  string
+		allowed bool
+	}{
+		{"UNKNOWN:1", "next_track", true},
+		{"UNKNOWN:1", "seek", true},
+		{"RADIO:1", "next_track", false},
+		{"RADIO:1", "previous_track", false},
+		{"RADIO:1", "seek", false},
+		{"RADIO:1", "play", true},
+		{"RADIO:1", "volume", true},
+		{"TRACK:1", "next_track", true},
+		{"TRACK:1", "seek", true},
+		{"TRACK:1", "shuffle", false},
+	}
+	for _, test := range tests {
+		if allowed := fc.isActionAllowed(test.groupID, test.action); allowed != test.allowed {
+			t.Errorf("%s on %s allowed %v, expected %v", test.action, test.groupID, allowed, test.allowed)
+		}
+	}
+}
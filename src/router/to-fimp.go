@@ -0,0 +1,286 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/futurehomeno/edge-sonos-adapter/model"
+	"github.com/futurehomeno/edge-sonos-adapter/sonos-api"
+	"github.com/futurehomeno/fimpgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// ToFimpRouter converts events pushed by Sonos players into FIMP reports
+type ToFimpRouter struct {
+	mqt       *fimpgo.MqttTransport
+	states    *model.States
+	client    *sonos.Client
+	eventCh   chan sonos.Event
+	refreshCh chan string
+}
+
+func NewToFimpRouter(mqt *fimpgo.MqttTransport, states *model.States, client *sonos.Client) *ToFimpRouter {
+	return &ToFimpRouter{mqt: mqt, states: states, client: client, eventCh: make(chan sonos.Event, 50), refreshCh: make(chan string, 1)}
+}
+
+func (tr *ToFimpRouter) Start() {
+	tr.client.SetEventHandler(func(evt sonos.Event) {
+		select {
+		case tr.eventCh <- evt:
+		default:
+			log.Warn("<tfimpr> Event queue is full, event dropped")
+		}
+	})
+	go func() {
+		for evt := range tr.eventCh {
+			tr.routeSonosEvent(evt)
+		}
+	}()
+}
+
+// RefreshCh returns channel which receives household id when group topology has changed and states must be reloaded
+func (tr *ToFimpRouter) RefreshCh() <-chan string {
+	return tr.refreshCh
+}
+
+func (tr *ToFimpRouter) routeSonosEvent(evt sonos.Event) {
+	log.Debugf("<tfimpr> New event %s from group %s", evt.Namespace, evt.GroupID)
+	if evt.Namespace == sonos.NamespaceGroups {
+		select {
+		case tr.refreshCh <- evt.HouseholdID:
+		default:
+		}
+		return
+	}
+	if evt.Namespace == sonos.NamespacePlayerVolume {
+		var volume sonos.VolumeResponse
+		if err := json.Unmarshal(evt.Body, &volume); err != nil {
+			log.Error("<tfimpr> Can't unmarshal player volume event. Err:", err)
+			return
+		}
+		tr.reportPlayerVolume(evt.PlayerID, &volume)
+		return
+	}
+	group, ok := tr.states.FindGroup(evt.GroupID)
+	if !ok {
+		log.Debug("<tfimpr> Event from unknown group ", evt.GroupID)
+		return
+	}
+	adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: group.FimpId}
+	// reports are collected while the state is locked and published after it is released
+	var msgs []*fimpgo.FimpMessage
+
+	switch evt.Namespace {
+	case sonos.NamespacePlayback:
+		var pbStatus sonos.PlaybackStatusResponse
+		if err := json.Unmarshal(evt.Body, &pbStatus); err != nil {
+			log.Error("<tfimpr> Can't unmarshal playback event. Err:", err)
+			return
+		}
+		pbStateValue := tr.client.SetCorrectValue(pbStatus.PlaybackState)
+		modes := model.PlayModes{
+			Repeat:    pbStatus.PlayModes.Repeat,
+			RepeatOne: pbStatus.PlayModes.RepeatOne,
+			Shuffle:   pbStatus.PlayModes.Shuffle,
+			Crossfade: pbStatus.PlayModes.Crossfade,
+		}
+		tr.states.Update(func(st *model.States) {
+			state := st.GroupState(group.GroupId)
+			state.PositionMillis = pbStatus.PositionMillis
+			if state.PlaybackState != pbStateValue {
+				msgs = append(msgs, fimpgo.NewMessage("evt.playback.report", "media_player", fimpgo.VTypeString, pbStateValue, nil, nil, nil))
+				state.PlaybackState = pbStateValue
+			}
+			if state.PlaybackActions == nil || *state.PlaybackActions != pbStatus.AvailablePlaybackActions {
+				msgs = append(msgs, fimpgo.NewMessage("evt.playback.capabilities_report", "media_player", fimpgo.VTypeBoolMap, MakeCapabilitiesReport(pbStatus.AvailablePlaybackActions), nil, nil, nil))
+				actions := pbStatus.AvailablePlaybackActions
+				state.PlaybackActions = &actions
+			}
+			if state.PlayModes != modes {
+				msgs = append(msgs, fimpgo.NewMessage("evt.playbackmode.report", "media_player", fimpgo.VTypeBoolMap, MakePlayModesReport(modes), nil, nil, nil))
+				state.PlayModes = modes
+			}
+		})
+
+	case sonos.NamespacePlaybackMetadata:
+		var metadata sonos.PlaybackMetadataResponse
+		if err := json.Unmarshal(evt.Body, &metadata); err != nil {
+			log.Error("<tfimpr> Can't unmarshal metadata event. Err:", err)
+			return
+		}
+		report := MakeMetadataReport(&metadata)
+		tr.states.Update(func(st *model.States) {
+			state := st.GroupState(group.GroupId)
+			if !reflect.DeepEqual(state.Metadata, report) {
+				msgs = append(msgs, fimpgo.NewMessage("evt.metadata.report", "media_player", fimpgo.VTypeObject, report, nil, nil, nil))
+			}
+			state.SetMetadata(&metadata, report)
+		})
+
+	case sonos.NamespaceGroupVolume:
+		var volume sonos.VolumeResponse
+		if err := json.Unmarshal(evt.Body, &volume); err != nil {
+			log.Error("<tfimpr> Can't unmarshal volume event. Err:", err)
+			return
+		}
+		groupProps := fimpgo.Props{"group": "true"}
+		tr.states.Update(func(st *model.States) {
+			state := st.GroupState(group.GroupId)
+			if state.Volume != volume.Volume {
+				msgs = append(msgs, fimpgo.NewMessage("evt.volume.report", "media_player", fimpgo.VTypeInt, volume.Volume, groupProps, nil, nil))
+				state.Volume = volume.Volume
+			}
+			if state.Muted != volume.Muted {
+				msgs = append(msgs, fimpgo.NewMessage("evt.mute.report", "media_player", fimpgo.VTypeBool, volume.Muted, groupProps, nil, nil))
+				state.Muted = volume.Muted
+			}
+			state.Fixed = volume.Fixed
+		})
+		if len(group.PlayersIds) == 1 {
+			// player reports are sent after the group reports
+			defer tr.reportPlayerVolume(fmt.Sprintf("%v", group.PlayersIds[0]), &volume)
+		}
+	}
+	for _, msg := range msgs {
+		tr.publish(adr, msg)
+	}
+}
+
+// reportPlayerVolume sends volume and mute reports of a single player if they have changed
+func (tr *ToFimpRouter) reportPlayerVolume(playerID string, volume *sonos.VolumeResponse) {
+	for _, player := range tr.states.GetPlayers() {
+		if player.Id != playerID {
+			continue
+		}
+		adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: player.FimpId}
+		var msgs []*fimpgo.FimpMessage
+		tr.states.Update(func(st *model.States) {
+			state := st.PlayerState(player.Id)
+			if state.Volume != volume.Volume {
+				msgs = append(msgs, fimpgo.NewMessage("evt.volume.report", "media_player", fimpgo.VTypeInt, volume.Volume, nil, nil, nil))
+				state.Volume = volume.Volume
+			}
+			if state.Muted != volume.Muted {
+				msgs = append(msgs, fimpgo.NewMessage("evt.mute.report", "media_player", fimpgo.VTypeBool, volume.Muted, nil, nil, nil))
+				state.Muted = volume.Muted
+			}
+		})
+		for _, msg := range msgs {
+			tr.publish(adr, msg)
+		}
+	}
+}
+
+func (tr *ToFimpRouter) publish(adr *fimpgo.Address, msg *fimpgo.FimpMessage) {
+	if err := tr.mqt.Publish(adr, msg); err != nil {
+		log.Error(err)
+	}
+	log.Infof("<tfimpr> New %s sent to fimp", msg.Type)
+}
+
+// MakeMetadataReport builds evt.metadata.report value. For radio stations track and album are not available,
+// container name is reported as artist instead.
+func MakeMetadataReport(metadata *sonos.PlaybackMetadataResponse) map[string]interface{} {
+	currentItem := metadata.CurrentItem
+	streamInfo := ""
+	isRadio := false
+	var imageURL string
+	if metadata.Container.Service.Name == "Sonos Radio" {
+		streamInfo = metadata.StreamInfo
+		currentItem = sonos.CurrentItem{}
+		if metadata.Container.Name != "" {
+			currentItem.Track.Artist.Name = metadata.Container.Name
+		}
+		isRadio = true
+	} else {
+		imageURL = currentItem.Track.ImageURL
+		if imageURL == "" {
+			imageURL = metadata.Container.ImageURL
+		}
+	}
+	return map[string]interface{}{
+		"album":           currentItem.Track.Album.Name,
+		"track":           currentItem.Track.Name,
+		"artist":          currentItem.Track.Artist.Name,
+		"image_url":       imageURL,
+		"stream_info":     streamInfo,
+		"is_radio":        isRadio,
+		"duration_millis": currentItem.Track.DurationMillis,
+		"source":          metadataSource(&metadata.Container, isRadio),
+	}
+}
+
+// metadataSource tells where the content comes from: line_in, tv, radio or service (music service, favorite or playlist)
+func metadataSource(container *sonos.Container, isRadio bool) string {
+	switch {
+	case strings.HasPrefix(container.Type, "linein.homeTheater"):
+		return "tv"
+	case strings.HasPrefix(container.Type, "linein"):
+		return "line_in"
+	case isRadio || container.Type == "station":
+		return "radio"
+	}
+	return "service"
+}
+
+// MakePositionProps builds evt.playback.report properties with track position and duration
+func MakePositionProps(pbStatus *sonos.PlaybackStatusResponse, durationMillis int64) fimpgo.Props {
+	return fimpgo.Props{
+		"position_millis": strconv.Itoa(pbStatus.PositionMillis),
+		"duration_millis": strconv.FormatInt(durationMillis, 10),
+	}
+}
+
+// MakePlayModesReport builds evt.playbackmode.report value
+func MakePlayModesReport(modes model.PlayModes) map[string]bool {
+	return map[string]bool{
+		"repeat":     modes.Repeat,
+		"repeat_one": modes.RepeatOne,
+		"shuffle":    modes.Shuffle,
+		"crossfade":  modes.Crossfade,
+	}
+}
+
+// MakeStateReport builds evt.state.report value with the last known state of the player and its group
+func MakeStateReport(group *sonos.Group, groupState *model.GroupState, playerState *model.PlayerState) map[string]interface{} {
+	members := []string{}
+	for _, id := range group.PlayersIds {
+		members = append(members, sonos.FimpIdFromPlayerId(fmt.Sprintf("%v", id)))
+	}
+	var capabilities map[string]bool
+	if groupState.PlaybackActions != nil {
+		capabilities = MakeCapabilitiesReport(*groupState.PlaybackActions)
+	}
+	return map[string]interface{}{
+		"group": map[string]interface{}{
+			"id":              group.GroupId,
+			"coordinator":     sonos.FimpIdFromPlayerId(group.CoordinatorId),
+			"members":         members,
+			"playback_state":  groupState.PlaybackState,
+			"position_millis": groupState.PositionMillis,
+			"modes":           MakePlayModesReport(groupState.PlayModes),
+			"capabilities":    capabilities,
+			"metadata":        groupState.Metadata,
+			"volume":          groupState.Volume,
+			"muted":           groupState.Muted,
+		},
+		"volume": playerState.Volume,
+		"muted":  playerState.Muted,
+	}
+}
+
+// MakeCapabilitiesReport builds evt.playback.capabilities_report value, keys are the same as in sup_playback and sup_modes
+func MakeCapabilitiesReport(actions sonos.PlaybackActions) map[string]bool {
+	return map[string]bool{
+		"next_track":     actions.CanSkip,
+		"previous_track": actions.CanSkipBack,
+		"seek":           actions.CanSeek,
+		"repeat":         actions.CanRepeat,
+		"repeat_one":     actions.CanRepeatOne,
+		"crossfade":      actions.CanCrossfade,
+		"shuffle":        actions.CanShuffle,
+	}
+}
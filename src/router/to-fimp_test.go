@@ -0,0 +1,46 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/futurehomeno/edge-sonos-adapter/sonos-api"
+)
+
+func TestMakeCapabilitiesReport(t *testing.T) {
+	tests := []struct {
+		name     string
+		actions  sonos.PlaybackActions
+		expected map[string]bool
+	}{
+		{
+			name:    "track",
+			actions: sonos.PlaybackActions{CanSkip: true, CanSkipBack: true, CanSeek: true, CanRepeat: true, CanRepeatOne: true, CanCrossfade: true, CanShuffle: true},
+			expected: map[string]bool{"next_track": true, "previous_track": true, "seek": true, "repeat": true,
+				"repeat_one": true, "crossfade": true, "shuffle": true},
+		},
+		{
+			name:    "radio",
+			actions: sonos.PlaybackActions{CanCrossfade: true},
+			expected: map[string]bool{"next_track": false, "previous_track": false, "seek": false, "repeat": false,
+				"repeat_one": false, "crossfade": true, "shuffle": false},
+		},
+	}
+	for _, test := range tests {
+		report := MakeCapabilitiesReport(test.actions)
+		if len(report) != len(test.expected) {
+			t.Errorf("%s: unexpected report %v", test.name, report)
+		}
+		for action, allowed := range test.expected {
+			if value, ok := report[action]; !ok || value != allowed {
+				t.Errorf("%s: %s is %v, expected %v", test.name, action, value, allowed)
+			}
+		}
+	}
+}
+
+func TestMakePositionProps(t *testing.T) {
+	props := MakePositionProps(&sonos.PlaybackStatusResponse{PositionMillis: 1000}, 1500000)
+	if props["position_millis"] != "1000" || props["duration_millis"] != "1500000" {
+		t.Errorf("Unexpected props %v", props)
+	}
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"reflect"
+	"syscall"
 	"time"
 
-	"github.com/pkg/errors"
 	"github.com/thoas/go-funk"
 
+	"github.com/futurehomeno/edge-sonos-adapter/media"
 	"github.com/futurehomeno/edge-sonos-adapter/model"
 	"github.com/futurehomeno/edge-sonos-adapter/router"
 	"github.com/futurehomeno/edge-sonos-adapter/sonos-api"
@@ -18,6 +24,13 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	pollInterval      = 15 * time.Second
+	reconcileInterval = 5 * time.Minute
+	// pollTimeout is the deadline of one poll of all households
+	pollTimeout = 2 * time.Minute
+)
+
 func main() {
 	var workDir string
 	flag.StringVar(&workDir, "c", "", "Work dir")
@@ -33,11 +46,24 @@ func main() {
 	states := model.NewStates(workDir)
 	err := configs.LoadFromFile()
 	if err != nil {
-		log.Fatal(errors.Wrap(err, "can't load config file."))
+		log.Fatal(fmt.Errorf("can't load config file.: %w", err))
 	}
 
 	client := sonos.NewClient(configs.Env, configs.AccessToken, configs.RefreshToken)
 	client.UpdateAuthParameters(configs.MqttServerURI)
+	client.Tokens().SetTokens(configs.GetTokens())
+	client.Tokens().SetPersistHandler(func(tokens sonos.Tokens) {
+		// saved right away, otherwise the hub comes back after restart with a refresh token which was already rotated
+		configs.SetTokens(tokens)
+		if err := configs.SaveToFile(); err != nil {
+			log.Error("<main> Can't save configurations . Err:", err)
+		}
+	})
+	client.EnableLocalControl(configs.LocalApiKey, configs.LocalHouseholds)
+	if err := states.LoadFromFile(); err != nil {
+		log.Error("<main> Can't load saved state. Err:", err)
+	}
+	client.RestoreTopology(states.GetGroups(), states.GetPlayers())
 	edgeapp.SetupLog(configs.LogFile, configs.LogLevel, configs.LogFormat)
 	log.Info("--------------Starting sonos----------------")
 	log.Info("Work directory : ", configs.WorkDir)
@@ -47,13 +73,48 @@ func main() {
 	err = mqtt.Start()
 	defer mqtt.Stop()
 
+	client.Tokens().SetStateHandler(func(state string, authErr *sonos.AuthError) {
+		appLifecycle.SetAuthState(model.State(state))
+		if authErr != nil {
+			appLifecycle.SetLastError(authErr.Code, authErr.Error())
+			publishAuthStatus(mqtt, state, authErr)
+		} else if state == sonos.AuthStateAuthenticated {
+			appLifecycle.SetLastError("", "")
+		}
+	})
+
 	responder := discovery.NewServiceDiscoveryResponder(mqtt)
 	responder.RegisterResource(model.GetDiscoveryResource())
 	responder.Start()
 
-	fimpRouter := router.NewFromFimpRouter(mqtt, appLifecycle, configs, states, client)
+	tts := media.NewLocalTTS()
+	if tts == nil {
+		log.Warn("<main> No offline text to speech engine found, cmd.audioclip.say is disabled")
+	}
+	mediaServer := media.NewServer(filepath.Join(configs.GetDataDir(), "media"), configs.MediaServerHost, configs.MediaServerPort, tts)
+	if err := mediaServer.Start(); err != nil {
+		log.Error("<main> Can't start media server. Err:", err)
+	}
+	defer mediaServer.Stop()
+
+	fimpRouter := router.NewFromFimpRouter(mqtt, appLifecycle, configs, states, client, mediaServer)
 	fimpRouter.Start()
 
+	toFimpRouter := router.NewToFimpRouter(mqtt, states, client)
+	toFimpRouter.Start()
+
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		<-sigCh
+		// cancels outstanding requests, so the adapter doesn't wait for slow Sonos responses when it is stopped
+		appLifecycle.SetAppState(model.AppStateTerminate, nil)
+		client.Tokens().Stop()
+		mediaServer.Stop()
+		mqtt.Stop()
+		os.Exit(0)
+	}()
+
 	appLifecycle.SetConnectionState(model.ConnStateDisconnected)
 
 	// Checking internet connection
@@ -62,14 +123,23 @@ func main() {
 	if err != nil {
 		log.Error("<main> Internet is not available, the adapter might not work.")
 	}
+	// expired token is refreshed right away, e.g. when the hub was offline
+	client.Tokens().Start()
+	defer client.Tokens().Stop()
 
 	if configs.IsAuthenticated() && err == nil {
-		appLifecycle.SetAuthState(model.AuthStateAuthenticated)
+		// the token may have been rejected by the refresh started above
+		appLifecycle.SetAuthState(model.State(client.Tokens().State()))
 		for i := 0; i < 5; i++ {
-			states.Households, err = client.GetHousehold()
+			var households []sonos.Household
+			households, err = client.GetHousehold(appLifecycle.Context())
 			if err == nil {
+				states.SetHouseholds(households)
 				appLifecycle.SetConnectionState(model.ConnStateConnected)
 				break
+			} else if sonos.IsPermanentAuthError(err) {
+				log.Error("<main> Sonos rejected the tokens, the user has to log in again. Err:", err.Error())
+				break
 			} else {
 				log.Error("<main> Can't get household.Retrying.... Err:", err.Error())
 				time.Sleep(time.Second * 10)
@@ -87,185 +157,161 @@ func main() {
 		appLifecycle.SetConfigState(model.ConfigStateNotConfigured)
 	}
 
+	go reportPositions(appLifecycle, configs, client, states, mqtt)
+
 	for {
 		appLifecycle.WaitForState("main", model.AppStateRunning)
 		log.Info("<main>Starting update loop")
-		LoadStates(configs, client, states, err, mqtt)
-		ticker := time.NewTicker(time.Duration(15) * time.Second)
-		for range ticker.C {
+		ctx, cancel := context.WithTimeout(appLifecycle.Context(), pollTimeout)
+		LoadStates(ctx, configs, client, states, err, mqtt)
+		cancel()
+		saveStates(states)
+		lastLoad := time.Now()
+		ticker := time.NewTicker(pollInterval)
+		for {
+			forced := false
+			select {
+			case <-ticker.C:
+			case <-toFimpRouter.RefreshCh():
+				forced = true
+			}
 			if appLifecycle.AppState() != model.AppStateRunning {
 				break
 			}
-			states = LoadStates(configs, client, states, err, mqtt)
+			if client.Tokens().LastError() != nil {
+				// nothing is polled until the user logs in again
+				continue
+			}
+			if updateThrottledState(appLifecycle, client) {
+				// polling would only use up the budget left for commands
+				continue
+			}
+			// groups with event subscriptions are only reconciled occasionally
+			if !forced && client.HasEventSubscriptions(states.GetGroups(), states.GetPlayers()) && time.Since(lastLoad) < reconcileInterval {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(appLifecycle.Context(), pollTimeout)
+			states = LoadStates(ctx, configs, client, states, err, mqtt)
+			cancel()
+			saveStates(states)
+			lastLoad = time.Now()
 		}
 		ticker.Stop()
 	}
 
 }
 
-func LoadStates(configs *model.Configs, client *sonos.Client, states *model.States, err error, mqtt *fimpgo.MqttTransport) *model.States {
+func saveStates(states *model.States) {
+	if err := states.SaveIfChanged(); err != nil {
+		log.Error("<main> Can't save state. Err:", err)
+	}
+}
 
-	if configs.AccessToken != "" && configs.AccessToken != "access_token" {
-		// ADD LOGIC TO HANDLE REFRESH TOKEN
-		// every 24 hours at least
-		// if millis is more than 12 hours after last authorization, make new
-		currentMillis := time.Now().UnixNano() / 1000000
-		refreshMillis := configs.LastAuthMillis + 43200000
+func LoadStates(ctx context.Context, configs *model.Configs, client *sonos.Client, states *model.States, err error, mqtt *fimpgo.MqttTransport) *model.States {
 
-		if currentMillis > refreshMillis {
-			log.Debug("<main> Access token expired , requesting new token")
-			newAccessToken, err := client.RefreshAccessToken(configs.RefreshToken)
-			if err != nil {
-				log.Error("<main> Can't refresh token")
-				log.Error(errors.Wrap(err, "refreshing access token"))
+	wantedHouseholds := configs.GetWantedHouseholds()
+	states.RetainHouseholds(wantedHouseholds)
+
+	for _, HouseholdID := range wantedHouseholds {
+		groups, players, err := client.GetGroupsAndPlayers(ctx, HouseholdID)
+		if err != nil {
+			// keep the last known (or saved) groups of the household, so commands can still be routed
+			log.Error("<main> Can't get groups and players. Err:", err)
+			if errors.Is(err, sonos.ErrRateLimited) {
 				return states
-			} else if newAccessToken != "" {
-				configs.AccessToken = newAccessToken
-				configs.LastAuthMillis = currentMillis
-				client.SetTokens(configs.AccessToken, configs.RefreshToken)
-				if err := configs.SaveToFile(); err != nil {
-					log.Error("<main> Can't save configurations . Err:", err)
-				}
 			}
+			continue
 		}
+		states.SetHousehold(HouseholdID, groups, players)
 	}
-	oldGroups := states.Groups
 
-	for i := 0; i < len(configs.WantedHouseholds); i++ {
-		HouseholdID := fmt.Sprintf("%v", configs.WantedHouseholds[i])
-
-		states.Groups, states.Players, err = client.GetGroupsAndPlayers(HouseholdID)
-		if err != nil {
-			log.Error("error")
+	for _, group := range states.GetGroups() {
+		if err := client.SubscribeEvents(ctx, group.GroupId); err != nil {
+			log.Debug("<main> Events not available, group state is polled. Err:", err)
 		}
 	}
-
-	for i, group := range states.Groups {
-		if len(oldGroups) != 0 && len(oldGroups) == len(states.Groups) {
-			states.Groups[i].OldReport = oldGroups[i].OldReport
-			states.Groups[i].OldPbStateValue = oldGroups[i].OldPbStateValue
-			states.Groups[i].OldPlayModes = oldGroups[i].OldPlayModes
-			states.Groups[i].OldVolume = oldGroups[i].OldVolume
-			states.Groups[i].OldMuted = oldGroups[i].OldMuted
-		} else if len(oldGroups) != len(states.Groups) {
-			return states
+	for _, player := range states.GetPlayers() {
+		if err := client.SubscribePlayerEvents(ctx, player.Id); err != nil {
+			log.Debug("<main> Events not available, player volume is polled. Err:", err)
 		}
-		group = states.Groups[i]
-
-		metadata, err := client.GetMetadata(group.GroupId)
-		if err == nil {
-			var imageURL string
-			states.Container = metadata.Container
-			states.CurrentItem = metadata.CurrentItem
-			states.NextItem = metadata.NextItem
-			if states.Container.Service.Name == "Sonos Radio" {
-				if metadata.StreamInfo != "" {
-					states.StreamInfo = metadata.StreamInfo
-				} else {
-					states.StreamInfo = ""
-				}
-				states.CurrentItem = sonos.CurrentItem{}
-				if metadata.Container.Name != "" {
-					states.CurrentItem.Track.Artist.Name = metadata.Container.Name
-				}
-
-				imageURL = ""
-				states.NextItem = sonos.NextItem{}
-				states.IsRadio = true
-			} else {
-				states.StreamInfo = ""
-				imageURL = states.CurrentItem.Track.ImageURL
-				states.IsRadio = false
-				if imageURL == "" {
-					imageURL = states.Container.ImageURL
-				}
-			}
+	}
 
-			// imageURL := states.Container.ImageURL
-			// if imageURL == "" {
-			// 	imageURL = states.CurrentItem.Track.ImageURL
-			// }
-
-			report := map[string]interface{}{
-				"album":       states.CurrentItem.Track.Album.Name,
-				"track":       states.CurrentItem.Track.Name,
-				"artist":      states.CurrentItem.Track.Artist.Name,
-				"image_url":   imageURL,
-				"stream_info": states.StreamInfo,
-				"is_radio":    states.IsRadio,
-			}
+	for _, group := range states.GetGroups() {
 
+		metadata, err := client.GetMetadata(ctx, group.GroupId)
+		if err == nil {
+			report := router.MakeMetadataReport(metadata)
 			adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: group.FimpId}
-			oldReportEqualsNewReport := reflect.DeepEqual(group.OldReport, report)
-			if !oldReportEqualsNewReport {
-				msg := fimpgo.NewMessage("evt.metadata.report", "media_player", fimpgo.VTypeObject, report, nil, nil, nil)
-				if err := mqtt.Publish(adr, msg); err != nil {
-					log.Error(err)
+			var msgs []*fimpgo.FimpMessage
+			states.Update(func(st *model.States) {
+				state := st.GroupState(group.GroupId)
+				if !reflect.DeepEqual(state.Metadata, report) {
+					msgs = append(msgs, fimpgo.NewMessage("evt.metadata.report", "media_player", fimpgo.VTypeObject, report, nil, nil, nil))
 				}
-				group.OldReport = report
-				log.Info("New metadata message sent to fimp")
-			}
+				state.SetMetadata(metadata, report)
+			})
+			publishReports(mqtt, adr, msgs)
 
-			pbState, err := client.PlaybackGetStatus(group.GroupId)
+			pbState, err := client.PlaybackGetStatus(ctx, group.GroupId)
 			if err != nil {
-				break
+				if errors.Is(err, sonos.ErrRateLimited) {
+					log.Warn("<main> Polling paused, Sonos rate limits requests")
+					break
+				}
+				log.Error("<main> Can't get playback status of group ", group.GroupId, ". Err:", err)
+				continue
 			}
-			volume, err := client.VolumeGet(group.GroupId)
+			volume, err := client.VolumeGet(ctx, group.GroupId)
 			if err != nil {
-				break
-			}
-
-			states.PlaybackState = pbState.PlaybackState
-			states.PlayModes.Crossfade = pbState.PlayModes.Crossfade
-			states.PlayModes.Repeat = pbState.PlayModes.Repeat
-			states.PlayModes.RepeatOne = pbState.PlayModes.RepeatOne
-			states.PlayModes.Shuffle = pbState.PlayModes.Shuffle
-			states.Volume = volume.Volume
-			states.Muted = volume.Muted
-			states.Fixed = volume.Fixed
-
-			pbStateValue := client.SetCorrectValue(states.PlaybackState)
-			if group.OldPbStateValue != pbStateValue {
-				msg := fimpgo.NewMessage("evt.playback.report", "media_player", fimpgo.VTypeString, pbStateValue, nil, nil, nil)
-				if err := mqtt.Publish(adr, msg); err != nil {
-					log.Error(err)
+				if errors.Is(err, sonos.ErrRateLimited) {
+					log.Warn("<main> Polling paused, Sonos rate limits requests")
+					break
 				}
-				group.OldPbStateValue = pbStateValue
-				log.Info("New playback.report sent to fimp")
-			}
-			oldPlayModesEqualsNewPlaymodes := reflect.DeepEqual(group.OldPlayModes, states.PlayModes)
-			playmodes := map[string]bool{
-				"repeat":     states.PlayModes.Repeat,
-				"repeat_one": states.PlayModes.RepeatOne,
-				"shuffle":    states.PlayModes.Shuffle,
-				"crossfade":  states.PlayModes.Crossfade,
+				log.Error("<main> Can't get volume of group ", group.GroupId, ". Err:", err)
+				continue
 			}
 
-			if !oldPlayModesEqualsNewPlaymodes {
-				msg := fimpgo.NewMessage("evt.playbackmode.report", "media_player", fimpgo.VTypeBoolMap, playmodes, nil, nil, nil)
-				if err := mqtt.Publish(adr, msg); err != nil {
-					log.Error(err)
-				}
-				group.OldPlayModes = states.PlayModes
-				log.Info("New playbackmode.report sent to fimp")
+			pbStateValue := client.SetCorrectValue(pbState.PlaybackState)
+			playModes := model.PlayModes{
+				Repeat:    pbState.PlayModes.Repeat,
+				RepeatOne: pbState.PlayModes.RepeatOne,
+				Shuffle:   pbState.PlayModes.Shuffle,
+				Crossfade: pbState.PlayModes.Crossfade,
 			}
-			if group.OldVolume != states.Volume {
-				msg := fimpgo.NewMessage("evt.volume.report", "media_player", fimpgo.VTypeInt, states.Volume, nil, nil, nil)
-				if err := mqtt.Publish(adr, msg); err != nil {
-					log.Error(err)
+			groupProps := fimpgo.Props{"group": "true"}
+			msgs = nil
+			states.Update(func(st *model.States) {
+				state := st.GroupState(group.GroupId)
+				state.PositionMillis = pbState.PositionMillis
+				if state.PlaybackState != pbStateValue {
+					msgs = append(msgs, fimpgo.NewMessage("evt.playback.report", "media_player", fimpgo.VTypeString, pbStateValue, nil, nil, nil))
+					state.PlaybackState = pbStateValue
 				}
-				group.OldVolume = states.Volume
-				log.Info("New volume.report sent to fimp")
-			}
-			if group.OldMuted != states.Muted {
-				msg := fimpgo.NewMessage("evt.mute.report", "media_player", fimpgo.VTypeBool, states.Muted, nil, nil, nil)
-				if err := mqtt.Publish(adr, msg); err != nil {
-					log.Error(err)
+				if state.PlaybackActions == nil || *state.PlaybackActions != pbState.AvailablePlaybackActions {
+					msgs = append(msgs, fimpgo.NewMessage("evt.playback.capabilities_report", "media_player", fimpgo.VTypeBoolMap, router.MakeCapabilitiesReport(pbState.AvailablePlaybackActions), nil, nil, nil))
+					actions := pbState.AvailablePlaybackActions
+					state.PlaybackActions = &actions
 				}
-				group.OldMuted = states.Muted
-				log.Info("New mute.report sent to fimp")
-			}
-			states.Groups[i] = group
+				if state.PlayModes != playModes {
+					msgs = append(msgs, fimpgo.NewMessage("evt.playbackmode.report", "media_player", fimpgo.VTypeBoolMap, router.MakePlayModesReport(playModes), nil, nil, nil))
+					state.PlayModes = playModes
+				}
+				if state.Volume != volume.Volume {
+					msgs = append(msgs, fimpgo.NewMessage("evt.volume.report", "media_player", fimpgo.VTypeInt, volume.Volume, groupProps, nil, nil))
+					state.Volume = volume.Volume
+				}
+				if state.Muted != volume.Muted {
+					msgs = append(msgs, fimpgo.NewMessage("evt.mute.report", "media_player", fimpgo.VTypeBool, volume.Muted, groupProps, nil, nil))
+					state.Muted = volume.Muted
+				}
+				state.Fixed = volume.Fixed
+			})
+			publishReports(mqtt, adr, msgs)
+			reportPlayerVolumes(ctx, client, states, group, volume, mqtt)
+		} else if errors.Is(err, sonos.ErrRateLimited) {
+			// the rest of the groups is polled after the pause
+			log.Warn("<main> Polling paused, Sonos rate limits requests")
+			break
 		} else {
 			log.Error("This is the one in service.go", err)
 		}
@@ -273,3 +319,104 @@ func LoadStates(configs *model.Configs, client *sonos.Client, states *model.Stat
 	log.Debug("ticker")
 	return states
 }
+
+// reportPlayerVolumes sends volume and mute reports of every group member. Volume of a single player group equals group volume.
+func reportPlayerVolumes(ctx context.Context, client *sonos.Client, states *model.States, group sonos.Group, groupVolume *sonos.VolumeResponse, mqtt *fimpgo.MqttTransport) {
+	for _, player := range states.GetPlayers() {
+		if !funk.Contains(group.PlayersIds, player.Id) {
+			continue
+		}
+		volume := groupVolume
+		if len(group.PlayersIds) > 1 {
+			var err error
+			if volume, err = client.PlayerVolumeGet(ctx, player.Id); err != nil {
+				log.Error("<main> Can't get player volume. Err:", err)
+				continue
+			}
+		}
+		adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: player.FimpId}
+		var msgs []*fimpgo.FimpMessage
+		states.Update(func(st *model.States) {
+			state := st.PlayerState(player.Id)
+			if state.Volume != volume.Volume {
+				msgs = append(msgs, fimpgo.NewMessage("evt.volume.report", "media_player", fimpgo.VTypeInt, volume.Volume, nil, nil, nil))
+				state.Volume = volume.Volume
+			}
+			if state.Muted != volume.Muted {
+				msgs = append(msgs, fimpgo.NewMessage("evt.mute.report", "media_player", fimpgo.VTypeBool, volume.Muted, nil, nil, nil))
+				state.Muted = volume.Muted
+			}
+		})
+		publishReports(mqtt, adr, msgs)
+	}
+}
+
+// updateThrottledState sets connection state to THROTTLED while Sonos rate limits requests and back to CONNECTED after that
+func updateThrottledState(appLifecycle *model.Lifecycle, client *sonos.Client) bool {
+	throttled := client.IsThrottled()
+	if throttled && appLifecycle.ConnectionState() != model.ConnStateThrottled {
+		appLifecycle.SetConnectionState(model.ConnStateThrottled)
+	} else if !throttled && appLifecycle.ConnectionState() == model.ConnStateThrottled {
+		appLifecycle.SetConnectionState(model.ConnStateConnected)
+	}
+	return throttled
+}
+
+// publishReports publishes reports collected while the state was locked
+func publishReports(mqtt *fimpgo.MqttTransport, adr *fimpgo.Address, msgs []*fimpgo.FimpMessage) {
+	for _, msg := range msgs {
+		if err := mqtt.Publish(adr, msg); err != nil {
+			log.Error(err)
+			continue
+		}
+		log.Info("New ", msg.Type, " sent to fimp")
+	}
+}
+
+// publishAuthStatus reports failed token refresh, a permanent error means the user has to log in again
+func publishAuthStatus(mqtt *fimpgo.MqttTransport, state string, authErr *sonos.AuthError) {
+	adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeAdapter, ResourceName: model.ServiceName, ResourceAddress: "1"}
+	status := model.AuthStatus{Status: state, ErrorText: authErr.Error(), ErrorCode: authErr.Code}
+	msg := fimpgo.NewMessage("evt.auth.status_report", model.ServiceName, fimpgo.VTypeObject, status, nil, nil, nil)
+	if err := mqtt.Publish(adr, msg); err != nil {
+		log.Error(err)
+	}
+}
+
+// reportPositions periodically sends track position of playing groups as evt.playback.report properties
+func reportPositions(appLifecycle *model.Lifecycle, configs *model.Configs, client *sonos.Client, states *model.States, mqtt *fimpgo.MqttTransport) {
+	for {
+		positionInterval := configs.GetPositionInterval()
+		if positionInterval <= 0 {
+			time.Sleep(pollInterval)
+			continue
+		}
+		time.Sleep(time.Duration(positionInterval) * time.Second)
+		if appLifecycle.AppState() != model.AppStateRunning || client.Tokens().LastError() != nil || client.IsThrottled() {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(appLifecycle.Context(), pollTimeout)
+		for _, group := range states.GetGroups() {
+			if states.GetGroupState(group.GroupId).PlaybackState != "play" {
+				continue
+			}
+			pbStatus, err := client.PlaybackGetStatus(ctx, group.GroupId)
+			if err != nil {
+				log.Error("<main> Can't get playback position. Err:", err)
+				continue
+			}
+			var duration int64
+			states.Update(func(st *model.States) {
+				state := st.GroupState(group.GroupId)
+				state.PositionMillis = pbStatus.PositionMillis
+				duration = state.DurationMillis()
+			})
+			adr := &fimpgo.Address{MsgType: fimpgo.MsgTypeEvt, ResourceType: fimpgo.ResourceTypeDevice, ResourceName: model.ServiceName, ResourceAddress: "1", ServiceName: "media_player", ServiceAddress: group.FimpId}
+			msg := fimpgo.NewMessage("evt.playback.report", "media_player", fimpgo.VTypeString, client.SetCorrectValue(pbStatus.PlaybackState), router.MakePositionProps(pbStatus, duration), nil, nil)
+			if err := mqtt.Publish(adr, msg); err != nil {
+				log.Error(err)
+			}
+		}
+		cancel()
+	}
+}
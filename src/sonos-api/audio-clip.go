@@ -1,48 +1,76 @@
 package sonos
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 )
 
+const (
+	ClipTypeChime  = "CHIME"
+	ClipTypeCustom = "CUSTOM"
+
+	ClipPriorityLow  = "LOW"
+	ClipPriorityHigh = "HIGH"
+)
+
 type AudioClipRequest struct {
 	Name      string `json:"name"`
 	AppID     string `json:"appId"`
-	StreamURL string `json:"streamUrl"`
+	StreamURL string `json:"streamUrl,omitempty"`
 	ClipType  string `json:"clipType"`
-	Volume    int    `json:"volume"`
-	Priority  string  `json:"priority"`
+	Volume    int    `json:"volume,omitempty"`
+	Priority  string `json:"priority"`
 }
 
-//AudioClipLoad - Plays audio clip from URL and plays it with set volume. URLs can be local and global.Supported audio format : mp3,wav,etc.
-func (clt *Client) AudioClipLoad(clipUrl string,volume int, playerId string) (bool, error) {
-	url := fmt.Sprintf("%s%s%s", "https://api.ws.sonos.com/control/api/v1/players/", playerId, "/audioClip")
-
-	body := AudioClipRequest{
-		Name:      "INTRUSION_ALARM_MSG",
-		AppID:     "futurehome.edge.app",
-		StreamURL: clipUrl,
-		ClipType:  "CUSTOM",
-		Volume:    volume,
-		Priority: "HIGH",
-	}
+// AudioClip is the clip created by the player, Id is used to cancel it
+type AudioClip struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	AppId    string `json:"appId"`
+	Priority string `json:"priority"`
+	ClipType string `json:"clipType"`
+}
 
-	type responseT struct {
-		ID       string `json:"id"`
-		Name     string `json:"name"`
-		AppId    string `json:"appId"`
-		Priority string `json:"priority"`
-		ClipType string `json:"clipType"`
+// AudioClipPlay plays the clip on the player. Missing clip type defaults to CUSTOM, missing priority to HIGH.
+// CHIME clips are built into the player and don't need stream url.
+func (clt *Client) AudioClipPlay(ctx context.Context, req AudioClipRequest, playerId string) (*AudioClip, error) {
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/players/", playerId, "/audioClip")
+	req.AppID = appID
+	if req.ClipType == "" {
+		req.ClipType = ClipTypeCustom
+	}
+	if req.Priority == "" {
+		req.Priority = ClipPriorityHigh
+	}
+	if req.Name == "" {
+		req.Name = req.ClipType
+	}
+	if req.ClipType == ClipTypeCustom && req.StreamURL == "" {
+		return nil, fmt.Errorf("stream url is required for custom audio clip")
 	}
-	var response responseT
 
-	binResponse, err := clt.doApiRequest(http.MethodPost, url, body)
+	binResponse, err := clt.doApiRequest(ctx, http.MethodPost, url, req)
+	if err != nil {
+		return nil, err
+	}
+	var response AudioClip
 	err = json.Unmarshal(binResponse, &response)
 	if err != nil {
 		log.Error("Error when unmarshalling body: ", err)
+		return nil, err
+	}
+	return &response, nil
+}
+
+// AudioClipCancel stops the clip if it is playing or removes it from the queue of the player
+func (clt *Client) AudioClipCancel(ctx context.Context, clipId string, playerId string) (bool, error) {
+	url := fmt.Sprintf("%s%s%s%s%s", controlURL, "/v1/players/", playerId, "/audioClip/", clipId)
+	_, err := clt.doApiRequest(ctx, http.MethodDelete, url, nil)
+	if err != nil {
 		return false, err
 	}
-	return true, err
+	return true, nil
 }
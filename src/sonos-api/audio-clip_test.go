@@ -0,0 +1,42 @@
+package sonos
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestClient_AudioClipPlay(t *testing.T) {
+	client, server := newRecordingClient(t)
+	defer server.Close()
+	server.respond("POST /players/RINCON_A/audioClip", http.StatusOK, `{"id":"CLIP_1","name":"doorbell","appId":"futurehome.edge.app","priority":"LOW","clipType":"CHIME"}`)
+
+	clip, err := client.AudioClipPlay(context.Background(), AudioClipRequest{ClipType: ClipTypeChime, Priority: ClipPriorityLow, Name: "doorbell"}, "RINCON_A")
+	if err != nil {
+		t.Fatal("Can't play audio clip. Err:", err)
+	}
+	if clip.ID != "CLIP_1" || clip.Priority != ClipPriorityLow || clip.ClipType != ClipTypeChime {
+		t.Fatalf("Clip must be read from the response, got %+v", clip)
+	}
+	request := server.last(t)
+	if request.body["clipType"] != "CHIME" || request.body["priority"] != "LOW" || request.body["name"] != "doorbell" {
+		t.Fatalf("Unexpected request %v", request.body)
+	}
+	if _, ok := request.body["streamUrl"]; ok {
+		t.Fatal("Chime must not send stream url")
+	}
+	if _, err := client.AudioClipPlay(context.Background(), AudioClipRequest{}, "RINCON_A"); err == nil || len(server.requests()) != 1 {
+		t.Fatal("Custom clip without stream url must fail without request")
+	}
+	// defaults of custom clips
+	client.AudioClipPlay(context.Background(), AudioClipRequest{StreamURL: "http://hub/clip.mp3"}, "RINCON_A")
+	if body := server.last(t).body; body["clipType"] != ClipTypeCustom || body["priority"] != ClipPriorityHigh || body["name"] != ClipTypeCustom {
+		t.Fatalf("Unexpected defaults %v", body)
+	}
+	if ok, err := client.AudioClipCancel(context.Background(), "CLIP_1", "RINCON_A"); !ok || err != nil {
+		t.Fatal("Can't cancel audio clip. Err:", err)
+	}
+	if request := server.last(t); request.String() != "DELETE /players/RINCON_A/audioClip/CLIP_1" {
+		t.Fatalf("Unexpected cancel request %s", request)
+	}
+}
@@ -2,11 +2,14 @@ package sonos
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -18,21 +21,22 @@ import (
 const (
 	controlURL       = "https://api.ws.sonos.com/control/api"
 	sonosPartnerCode = "sonos"
+
+	CapabilityPlayback   = "PLAYBACK"
+	CapabilityAudioClip  = "AUDIO_CLIP"
+	CapabilityHTPlayback = "HT_PLAYBACK"
+	CapabilityLineIn     = "LINE_IN"
 )
 
 type (
-	Config struct {
-		ErrorCode  int    `json:"errorCode"`
-		Message    string `json:"message"`
-		StatusCode int    `json:"statusCode"`
-		Success    bool   `json:"success"`
-	}
-
 	Client struct {
 		oauth2Client *edgeapp.FhOAuth2Client
 		httpClient   *http.Client
-		accessToken  string
-		refreshToken string
+		tokens       *TokenManager
+		limiter      *rateLimiter
+		localMux     sync.RWMutex // protects local and eventHandler, local control is enabled while commands are running
+		local        *LocalTransport
+		eventHandler EventHandler
 	}
 
 	Household struct {
@@ -43,26 +47,17 @@ type (
 		GroupId       string `json:"id"`
 		OnlyGroupId   string
 		FimpId        string
+		HouseholdId   string
 		Name          string        `json:"name"`
 		CoordinatorId string        `json:"coordinatorId"`
 		PlaybackState string        `json:"playbackState"`
 		PlayersIds    []interface{} `json:"playerIds"`
-
-		OldReport       map[string]interface{} `json:"oldReport"`
-		OldPbStateValue string                 `json:"oldPbStateValue"`
-		OldPlayModes    struct {
-			Repeat    bool `json:"repeat"`
-			RepeatOne bool `json:"repeatOne"`
-			Shuffle   bool `json:"shuffle"`
-			Crossfade bool `json:"crossfade"`
-		}
-		OldVolume int  `json:"oldVolume"`
-		OldMuted  bool `json:"oldMuted"`
 	}
 
 	Player struct {
 		Id             string `json:"id"`
 		FimpId         string
+		HouseholdId    string
 		Name           string        `json:"name"`
 		WebSocketUrl   string        `json:"websocketUrl"`
 		SWVersion      string        `json:"softwareVersion"`
@@ -158,13 +153,23 @@ type (
 	}
 )
 
+// HasCapability returns true if the player reports the capability, e.g. CapabilityHTPlayback
+func (p *Player) HasCapability(capability string) bool {
+	for _, c := range p.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
 func NewClient(env, accessToken, refreshToken string) *Client {
 	authClient := edgeapp.NewFhOAuth2Client(sonosPartnerCode, sonosPartnerCode, env)
 
 	return &Client{
-		refreshToken: refreshToken,
-		accessToken:  accessToken,
+		tokens:       NewTokenManager(authClient, Tokens{AccessToken: accessToken, RefreshToken: refreshToken}),
 		oauth2Client: authClient,
+		limiter:      newRateLimiter(),
 		httpClient:   &http.Client{Timeout: 30 * time.Second}, // Very important to set timeout
 	}
 }
@@ -173,37 +178,44 @@ func (clt *Client) SetHubAuthToken(token string) {
 	clt.oauth2Client.SetHubToken(token)
 }
 
-func (clt *Client) SetTokens(accessToken, refreshToken string) {
-	clt.accessToken = accessToken
-	clt.refreshToken = refreshToken
+// Tokens returns manager of the account tokens
+func (clt *Client) Tokens() *TokenManager {
+	return clt.tokens
 }
 
 func (clt *Client) UpdateAuthParameters(mqttBrokerUri string) {
 	clt.oauth2Client.SetParameters(mqttBrokerUri, "", "", 0, 0, 0, 0)
 }
 
-func (clt *Client) RefreshAccessToken(refreshToken string) (string, error) {
-	if refreshToken == "" {
-		refreshToken = clt.refreshToken
-	}
-	if refreshToken == "" {
-		log.Error("<client> Empty refresh token")
-		return "", fmt.Errorf("empty refresh token")
+// EnableLocalControl routes group commands of the given households over the player WebSocket, falling back to the cloud API on failure.
+// Passing an empty list of households disables local control.
+func (clt *Client) EnableLocalControl(apiKey string, households []string) {
+	var local *LocalTransport
+	clt.localMux.Lock()
+	if len(households) > 0 {
+		local = NewLocalTransport(apiKey, households)
+		local.SetEventHandler(clt.eventHandler)
 	}
-	resp, err := clt.oauth2Client.ExchangeRefreshToken(refreshToken)
-	if err != nil {
-		log.Error("can't fetch new access token", err)
-		return "", err
+	previous := clt.local
+	clt.local = local
+	clt.localMux.Unlock()
+	if previous != nil {
+		previous.Close()
 	}
-	log.Debug("<client> New access token : ", resp.AccessToken)
-	clt.accessToken = resp.AccessToken
-	return resp.AccessToken, nil
 }
 
-func (clt *Client) GetHousehold() ([]Household, error) {
+// localTransport returns the local transport, or nil if local control is disabled. Callers must use the returned
+// value, not the field, as local control can be enabled or disabled at any time.
+func (clt *Client) localTransport() *LocalTransport {
+	clt.localMux.RLock()
+	defer clt.localMux.RUnlock()
+	return clt.local
+}
+
+func (clt *Client) GetHousehold(ctx context.Context) ([]Household, error) {
 	url := fmt.Sprintf("%s%s", controlURL, "/v1/households")
 
-	body, err := clt.doApiRequest(http.MethodGet, url, nil)
+	body, err := clt.doApiRequest(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -219,39 +231,104 @@ func (clt *Client) GetHousehold() ([]Household, error) {
 	return response.Households, nil
 }
 
-// do a generic HTTP request
+// do a generic HTTP request, error responses are returned as *APIError. Requests are held back by the rate limiter of
+// the household, GET requests are retried after network and server errors and every request is retried after a short 429.
 func (clt *Client) doHttpRequest(req *http.Request) ([]byte, error) {
+	householdID := clt.limiter.householdOf(req.URL.Path)
 	var err error
 	var resp *http.Response
-	for i := 0; i < 3; i++ {
+	for i := 0; ; i++ {
+		if err = clt.limiter.Wait(req.Context(), householdID); err != nil {
+			return nil, err
+		}
+		if i > 0 && req.GetBody != nil {
+			// body was consumed by the previous attempt
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		canRetry := i < requestRetries
 		resp, err = clt.httpClient.Do(req)
 		if err != nil {
+			if req.Context().Err() != nil {
+				// canceled or out of time, not a failure of Sonos
+				return nil, req.Context().Err()
+			}
+			if canRetry && req.Method == http.MethodGet {
+				pause := retryBackoff(i)
+				log.Warnf("<client> %s %s failed, retrying in %s. Err: %s", req.Method, req.URL.Path, pause, err)
+				if err := sleepContext(req.Context(), pause); err != nil {
+					return nil, err
+				}
+				continue
+			}
 			return nil, err
 		}
-		if resp.StatusCode == 200 {
+		if resp.StatusCode == http.StatusTooManyRequests {
+			pause := retryAfter(resp)
+			log.Warnf("<client> Rate limited by Sonos, requests of household %q are paused for %s", householdID, pause)
+			clt.limiter.Throttled(householdID, pause)
+			if canRetry && pause <= maxRequestWait {
+				// the request was not executed, Wait holds it back until the pause ends
+				resp.Body.Close()
+				continue
+			}
 			break
-		} else if resp.StatusCode == 401 {
-			log.Info("Invalid token . Retrying")
-			_, err = clt.RefreshAccessToken(clt.refreshToken)
-			if err != nil {
-				time.Sleep(time.Second * 5)
-			} else {
-				req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", clt.accessToken))
+		}
+		if resp.StatusCode >= 500 {
+			if canRetry && req.Method == http.MethodGet {
+				pause := retryBackoff(i)
+				log.Warnf("<client> %s %s failed with %d, retrying in %s", req.Method, req.URL.Path, resp.StatusCode, pause)
+				resp.Body.Close()
+				if err := sleepContext(req.Context(), pause); err != nil {
+					return nil, err
+				}
+				continue
 			}
-		} else if resp.StatusCode != 200 {
-			log.Error("Bad HTTP return code ", resp.StatusCode)
+			break
+		}
+		if resp.StatusCode != http.StatusUnauthorized || !canRetry {
+			break
+		}
+		log.Info("Invalid token . Retrying")
+		resp.Body.Close()
+		staleToken := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		accessToken, err := clt.tokens.Refresh(staleToken)
+		if IsPermanentAuthError(err) {
 			return nil, err
+		} else if err != nil {
+			select {
+			case <-time.After(time.Second * 5):
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+		} else {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 		}
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		log.Error("Bad HTTP return code ", resp.StatusCode)
-		return nil, fmt.Errorf("bad HTTP return code %d", resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := newAPIError(resp.StatusCode, body)
+		log.Errorf("<client> %s %s failed. Err: %s", req.Method, req.URL.Path, apiErr)
+		return nil, apiErr
 	}
-	return ioutil.ReadAll(resp.Body)
+	return body, nil
 }
 
-func (clt *Client) doApiRequest(method, url string, jsonRequest interface{}) ([]byte, error) {
+// IsThrottled returns true if requests are held back because Sonos responded with 429 or a household used up its request budget
+func (clt *Client) IsThrottled() bool {
+	return clt.limiter.IsThrottled()
+}
+
+func (clt *Client) doApiRequest(ctx context.Context, method, url string, jsonRequest interface{}) ([]byte, error) {
+	if authErr := clt.tokens.LastError(); authErr != nil {
+		// the user has to log in again, Sonos would reject the request anyway
+		return nil, authErr
+	}
 	var requestBody io.Reader
 	var err error
 	if jsonRequest != nil {
@@ -262,17 +339,94 @@ func (clt *Client) doApiRequest(method, url string, jsonRequest interface{}) ([]
 		}
 		requestBody = bytes.NewBuffer(bytesRepresentation)
 	}
-	req, err := http.NewRequest(method, url, requestBody)
+	req, err := http.NewRequestWithContext(ctx, method, url, requestBody)
 	if err != nil {
 		log.Error(errors.Wrap(err, "getting metadata"))
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Add("Accept", "*/*")
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", clt.accessToken))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", clt.tokens.AccessToken()))
 	return clt.doHttpRequest(req)
 }
 
+// SetEventHandler sets handler for events pushed by players over local connections
+func (clt *Client) SetEventHandler(handler EventHandler) {
+	clt.localMux.Lock()
+	defer clt.localMux.Unlock()
+	clt.eventHandler = handler
+	if clt.local != nil {
+		clt.local.SetEventHandler(handler)
+	}
+}
+
+// SubscribeEvents subscribes to playback, metadata, volume and group events of the group.
+// Returns error if the group is not reachable over the local transport.
+func (clt *Client) SubscribeEvents(ctx context.Context, groupID string) error {
+	return clt.subscribe(ctx, groupID, EventNamespaces)
+}
+
+// SubscribePlayerEvents subscribes to player volume events
+func (clt *Client) SubscribePlayerEvents(ctx context.Context, playerID string) error {
+	return clt.subscribe(ctx, playerID, PlayerEventNamespaces)
+}
+
+func (clt *Client) subscribe(ctx context.Context, id string, namespaces []string) error {
+	local := clt.localTransport()
+	if local == nil || !local.HasGroup(id) {
+		return fmt.Errorf("events are not available for %s", id)
+	}
+	for _, namespace := range namespaces {
+		if err := local.Subscribe(ctx, id, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasEventSubscriptions returns true if state of every group and player is pushed by events, so polling can be slowed down
+func (clt *Client) HasEventSubscriptions(groups []Group, players []Player) bool {
+	local := clt.localTransport()
+	if local == nil || len(groups) == 0 {
+		return false
+	}
+	for _, group := range groups {
+		for _, namespace := range EventNamespaces {
+			if !local.IsSubscribed(group.GroupId, namespace) {
+				return false
+			}
+		}
+	}
+	for _, player := range players {
+		for _, namespace := range PlayerEventNamespaces {
+			if !local.IsSubscribed(player.Id, namespace) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// doLocalRequest tries to execute group command over local transport. Returns false if the group has no local route or
+// the player can't be connected, in that case the caller should fall back to the cloud API. Once the command was sent
+// to the player it is not sent again over the cloud, it might have been executed (e.g. togglePlayPause), so timeouts
+// and rejected commands are returned as errors.
+func (clt *Client) doLocalRequest(ctx context.Context, groupID, namespace, command string, body, out interface{}) (bool, error) {
+	local := clt.localTransport()
+	if local == nil || !local.HasGroup(groupID) {
+		return false, nil
+	}
+	err := local.Command(ctx, groupID, namespace, command, body, out)
+	if isLocalUnavailable(err) {
+		log.Warnf("<client> Local %s/%s failed, falling back to cloud. Err:%s", namespace, command, err.Error())
+		return false, nil
+	} else if err != nil {
+		log.Errorf("<client> Local %s/%s failed. Err:%s", namespace, command, err.Error())
+		return true, err
+	}
+	return true, nil
+}
+
 func (clt *Client) SetCorrectValue(val string) string {
 	log.Debug(val)
 	if val == "PLAYBACK_STATE_PLAYING" {
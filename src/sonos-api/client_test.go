@@ -1,7 +1,11 @@
 package sonos
 
 import (
+	"context"
+	"errors"
 	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -10,41 +14,50 @@ const accessToken = ""
 const refreshToken = ""
 const hubToken = ""
 
+// skipLiveTest skips tests which talk to the real Sonos cloud, they need network and the tokens above
+func skipLiveTest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("live Sonos cloud test, skipped in short mode")
+	}
+}
+
 func TestClient_GetHousehold(t *testing.T) {
+	skipLiveTest(t)
 	log.SetLevel(log.DebugLevel)
-	client := NewClient("beta",accessToken,refreshToken)
+	client := NewClient("beta", accessToken, refreshToken)
 	client.SetHubAuthToken(hubToken)
 
-	household , err := client.GetHousehold()
-	if err != nil{
-		t.Fatal("Can't retrieve household . Err:",err.Error())
+	household, err := client.GetHousehold(context.Background())
+	if err != nil {
+		t.Fatal("Can't retrieve household . Err:", err.Error())
 	}
-	if len(household)==0 {
+	if len(household) == 0 {
 		t.Fatal("Empty household")
-	}else {
+	} else {
 		t.Log(household)
 		t.Log("All good")
 	}
 }
 
 func TestClient_GetGroupsAndPlayers(t *testing.T) {
+	skipLiveTest(t)
 	log.SetLevel(log.DebugLevel)
-	client := NewClient("beta",accessToken,refreshToken)
+	client := NewClient("beta", accessToken, refreshToken)
 	client.SetHubAuthToken(hubToken)
 
-	household , err := client.GetHousehold()
-	if err != nil{
-		t.Fatal("Can't retrieve household . Err:",err.Error())
+	household, err := client.GetHousehold(context.Background())
+	if err != nil {
+		t.Fatal("Can't retrieve household . Err:", err.Error())
 	}
-	if len(household)==0 {
+	if len(household) == 0 {
 		t.Fatal("Empty household")
-	}else {
+	} else {
 		t.Log(household)
 		t.Log("All good")
 	}
-	groups,players,err := client.GetGroupsAndPlayers(household[0].ID)
+	groups, players, err := client.GetGroupsAndPlayers(context.Background(), household[0].ID)
 	if err != nil {
-		t.Fatal("Can't get groups and Players , Err:",err.Error())
+		t.Fatal("Can't get groups and Players , Err:", err.Error())
 	}
 	if len(groups) == 0 || len(players) == 0 {
 		t.Fatal("List or groups or players is empty")
@@ -55,100 +68,127 @@ func TestClient_GetGroupsAndPlayers(t *testing.T) {
 }
 
 func TestClient_PlaybackSet(t *testing.T) {
+	skipLiveTest(t)
 	log.SetLevel(log.DebugLevel)
-	client := NewClient("beta",accessToken,refreshToken)
+	client := NewClient("beta", accessToken, refreshToken)
 	client.SetHubAuthToken(hubToken)
 
-	household , err := client.GetHousehold()
-	if err != nil{
-		t.Fatal("Can't retrieve household . Err:",err.Error())
+	household, err := client.GetHousehold(context.Background())
+	if err != nil {
+		t.Fatal("Can't retrieve household . Err:", err.Error())
 	}
-	if len(household)==0 {
+	if len(household) == 0 {
 		t.Fatal("Empty household")
-	}else {
+	} else {
 		t.Log(household)
 		t.Log("All good")
 	}
 
-	groups,players,err := client.GetGroupsAndPlayers(household[0].ID)
+	groups, players, err := client.GetGroupsAndPlayers(context.Background(), household[0].ID)
 	if err != nil {
-		t.Fatal("Can't get groups and Players , Err:",err.Error())
+		t.Fatal("Can't get groups and Players , Err:", err.Error())
 	}
 	if len(groups) == 0 || len(players) == 0 {
 		t.Fatal("List or groups or players is empty")
 	}
 
-	client.PlaybackSet("toggle_play_pause",groups[0].GroupId)
+	client.PlaybackSet(context.Background(), "toggle_play_pause", groups[0].GroupId)
 }
 
 func TestClient_VolumeSet(t *testing.T) {
+	skipLiveTest(t)
 	log.SetLevel(log.DebugLevel)
-	client := NewClient("beta",accessToken,refreshToken)
+	client := NewClient("beta", accessToken, refreshToken)
 	client.SetHubAuthToken(hubToken)
 
-	household , err := client.GetHousehold()
-	if err != nil{
-		t.Fatal("Can't retrieve household . Err:",err.Error())
+	household, err := client.GetHousehold(context.Background())
+	if err != nil {
+		t.Fatal("Can't retrieve household . Err:", err.Error())
 	}
-	if len(household)==0 {
+	if len(household) == 0 {
 		t.Fatal("Empty household")
-	}else {
+	} else {
 		t.Log(household)
 		t.Log("All good")
 	}
 
-	groups,players,err := client.GetGroupsAndPlayers(household[0].ID)
+	groups, players, err := client.GetGroupsAndPlayers(context.Background(), household[0].ID)
 	if err != nil {
-		t.Fatal("Can't get groups and Players , Err:",err.Error())
+		t.Fatal("Can't get groups and Players , Err:", err.Error())
 	}
 	if len(groups) == 0 || len(players) == 0 {
 		t.Fatal("List or groups or players is empty")
 	}
 	groupId := groups[0].GroupId
-	volObj , err := client.VolumeGet(groupId)
+	volObj, err := client.VolumeGet(context.Background(), groupId)
 	if err != nil {
-		t.Fatal("Can't get volume object , Err:",err.Error())
+		t.Fatal("Can't get volume object , Err:", err.Error())
 	}
 	currVolume := int64(volObj.Volume)
-	t.Log("Current volume level = ",currVolume)
-	_,err = client.VolumeSet(30,groupId)
+	t.Log("Current volume level = ", currVolume)
+	_, err = client.VolumeSet(context.Background(), 30, groupId)
 	if err != nil {
-		t.Fatal("Can't set volume , Err:",err.Error())
+		t.Fatal("Can't set volume , Err:", err.Error())
 	}
-	time.Sleep(time.Second*3)
-	_,err = client.VolumeSet(currVolume,groupId)
+	time.Sleep(time.Second * 3)
+	_, err = client.VolumeSet(context.Background(), currVolume, groupId)
 	if err != nil {
-		t.Fatal("Can't set volume , Err:",err.Error())
+		t.Fatal("Can't set volume , Err:", err.Error())
 	}
 
 }
 
 func TestClient_AudioClipLoad(t *testing.T) {
+	skipLiveTest(t)
 	log.SetLevel(log.DebugLevel)
-	client := NewClient("beta",accessToken,refreshToken)
+	client := NewClient("beta", accessToken, refreshToken)
 	client.SetHubAuthToken(hubToken)
 
-	household , err := client.GetHousehold()
-	if err != nil{
-		t.Fatal("Can't retrieve household . Err:",err.Error())
+	household, err := client.GetHousehold(context.Background())
+	if err != nil {
+		t.Fatal("Can't retrieve household . Err:", err.Error())
 	}
-	if len(household)==0 {
+	if len(household) == 0 {
 		t.Fatal("Empty household")
-	}else {
+	} else {
 		t.Log(household)
 		t.Log("All good")
 	}
 
-	groups,players,err := client.GetGroupsAndPlayers(household[0].ID)
+	groups, players, err := client.GetGroupsAndPlayers(context.Background(), household[0].ID)
 	if err != nil {
-		t.Fatal("Can't get groups and Players , Err:",err.Error())
+		t.Fatal("Can't get groups and Players , Err:", err.Error())
 	}
 	if len(groups) == 0 || len(players) == 0 {
 		t.Fatal("List or groups or players is empty")
 	}
 
-	client.AudioClipLoad("https://storage.googleapis.com/fh-flow-repo/burglar_alarm_voice.mp3",50,players[0].Id)
-	//client.AudioClipLoad("http://192.168.86.43/audio/burglar_alarm_voice.mp3",30,players[0].Id)
-	//client.AudioClipLoad("http://192.168.86.43/audio/lookdave.wav",50,players[0].Id)
+	client.AudioClipPlay(context.Background(), AudioClipRequest{Name: "INTRUSION_ALARM_MSG", StreamURL: "https://storage.googleapis.com/fh-flow-repo/burglar_alarm_voice.mp3", Volume: 50}, players[0].Id)
+	//client.AudioClipPlay(context.Background(), AudioClipRequest{StreamURL: "http://192.168.86.43/audio/burglar_alarm_voice.mp3", Volume: 30}, players[0].Id)
+	//client.AudioClipPlay(context.Background(), AudioClipRequest{StreamURL: "http://192.168.86.43/audio/lookdave.wav", Volume: 50}, players[0].Id)
 }
 
+func TestClient_ContextDeadline(t *testing.T) {
+	var requests int32
+	client, server := newCloudTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.VolumeGet(ctx, "RINCON_A:1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Request must fail when the deadline is exceeded, got", err)
+	}
+	if time.Since(start) > time.Second || atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("Request must not be retried after the deadline, got %d requests in %s", requests, time.Since(start))
+	}
+	if _, err := client.VolumeGet(ctx, "RINCON_A:1"); !errors.Is(err, context.DeadlineExceeded) || atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("Request with expired context must not be sent, got %d requests, err: %v", requests, err)
+	}
+}
@@ -0,0 +1,122 @@
+package sonos
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// rewriteTransport sends all cloud requests to the test server
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (rt *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newCloudTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient("beta", "token", "refresh")
+	client.httpClient.Transport = &rewriteTransport{target: target}
+	return client, server
+}
+
+// recordedRequest is a request received by recordingServer, path is without /control/api/v1
+type recordedRequest struct {
+	method string
+	path   string
+	body   map[string]interface{}
+}
+
+func (r recordedRequest) String() string {
+	return r.method + " " + r.path
+}
+
+type cannedResponse struct {
+	status int
+	body   string
+}
+
+// recordingServer is a fake Sonos cloud. It records requests and answers them with the response set by respond,
+// requests without a response get 200 with an empty object.
+type recordingServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	received  []recordedRequest
+	responses map[string]cannedResponse
+}
+
+func newRecordingClient(t *testing.T) (*Client, *recordingServer) {
+	rs := &recordingServer{responses: make(map[string]cannedResponse)}
+	client, server := newCloudTestClient(t, rs.handle)
+	rs.Server = server
+	return client, rs
+}
+
+// respond sets the response of request "METHOD /path", e.g. "GET /players/RINCON_B/playerVolume"
+func (rs *recordingServer) respond(request string, status int, body string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.responses[request] = cannedResponse{status: status, body: body}
+}
+
+func (rs *recordingServer) handle(w http.ResponseWriter, r *http.Request) {
+	request := recordedRequest{method: r.Method, path: strings.TrimPrefix(r.URL.Path, "/control/api/v1")}
+	if body, _ := ioutil.ReadAll(r.Body); len(body) > 0 {
+		json.Unmarshal(body, &request.body)
+	}
+	rs.mu.Lock()
+	rs.received = append(rs.received, request)
+	response, ok := rs.responses[request.String()]
+	rs.mu.Unlock()
+	if !ok {
+		response = cannedResponse{status: http.StatusOK, body: `{}`}
+	}
+	w.WriteHeader(response.status)
+	w.Write([]byte(response.body))
+}
+
+// requests returns received requests in order
+func (rs *recordingServer) requests() []recordedRequest {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]recordedRequest(nil), rs.received...)
+}
+
+// last returns the last received request, it fails the test if there is none
+func (rs *recordingServer) last(t *testing.T) recordedRequest {
+	t.Helper()
+	requests := rs.requests()
+	if len(requests) == 0 {
+		t.Fatal("No request received")
+	}
+	return requests[len(requests)-1]
+}
+
+// sent returns received requests as "METHOD /path" lines
+func (rs *recordingServer) sent() string {
+	var lines []string
+	for _, request := range rs.requests() {
+		lines = append(lines, request.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// reset forgets received requests, responses are kept
+func (rs *recordingServer) reset() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.received = nil
+}
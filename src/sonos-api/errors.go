@@ -0,0 +1,89 @@
+package sonos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Kinds of client errors, check them with errors.Is
+var (
+	ErrUnauthorized  = errors.New("unauthorized")
+	ErrNotFound      = errors.New("group or player not found")
+	ErrRateLimited   = errors.New("rate limited")
+	ErrPlayerOffline = errors.New("player offline")
+	ErrBadRequest    = errors.New("bad request")
+)
+
+// statusHouseholdOffline is returned by Sonos when the players of the household can't be reached
+const statusHouseholdOffline = 499
+
+// APIError is an error response of Sonos, sent by the cloud API or over a local connection
+type APIError struct {
+	StatusCode int    `json:"-"`         // HTTP status, 0 for local commands
+	Code       string `json:"errorCode"` // Sonos error code, e.g. ERROR_RESOURCE_GONE
+	Reason     string `json:"reason"`
+	kind       error
+}
+
+func (e *APIError) Error() string {
+	text := e.Code
+	if text == "" && e.StatusCode != 0 {
+		text = fmt.Sprintf("HTTP %d", e.StatusCode)
+	}
+	if e.Reason != "" && text != "" {
+		text = fmt.Sprintf("%s: %s", text, e.Reason)
+	} else if e.Reason != "" {
+		text = e.Reason
+	}
+	return "sonos error " + text
+}
+
+// Is makes errors.Is(err, ErrNotFound) and other kinds work
+func (e *APIError) Is(target error) bool {
+	return e.kind != nil && e.kind == target
+}
+
+// newAPIError decodes the error body, {"errorCode": "ERROR_RESOURCE_GONE", "reason": ""}. Bodies in other formats are kept as reason.
+func newAPIError(statusCode int, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: statusCode}
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Code == "" {
+		apiErr.Code = ""
+		apiErr.Reason = strings.TrimSpace(string(body))
+		if len(apiErr.Reason) > 200 {
+			apiErr.Reason = apiErr.Reason[:200]
+		}
+	}
+	apiErr.kind = classifyAPIError(statusCode, apiErr.Code)
+	return apiErr
+}
+
+// classifyAPIError returns kind of the error by the Sonos error code, or by HTTP status if the code is unknown
+func classifyAPIError(statusCode int, code string) error {
+	switch code {
+	case "ERROR_RESOURCE_GONE", "ERROR_INVALID_OBJECT_ID":
+		return ErrNotFound
+	case "ERROR_NOT_AUTHORIZED":
+		return ErrUnauthorized
+	case "ERROR_INVALID_PARAMETER", "ERROR_MISSING_PARAMETERS", "ERROR_INVALID_SYNTAX", "ERROR_UNSUPPORTED_NAMESPACE", "ERROR_UNSUPPORTED_COMMAND":
+		return ErrBadRequest
+	}
+	if strings.Contains(code, "OFFLINE") {
+		return ErrPlayerOffline
+	}
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case statusCode == http.StatusNotFound || statusCode == http.StatusGone:
+		return ErrNotFound
+	case statusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case statusCode == statusHouseholdOffline:
+		return ErrPlayerOffline
+	case statusCode >= 400 && statusCode < 500:
+		return ErrBadRequest
+	}
+	return nil
+}
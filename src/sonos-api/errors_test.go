@@ -0,0 +1,51 @@
+package sonos
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		kind   error
+		code   string
+	}{
+		{http.StatusGone, `{"_objectType":"globalError","errorCode":"ERROR_RESOURCE_GONE","reason":"group not found"}`, ErrNotFound, "ERROR_RESOURCE_GONE"},
+		{http.StatusBadRequest, `{"errorCode":"ERROR_INVALID_OBJECT_ID"}`, ErrNotFound, "ERROR_INVALID_OBJECT_ID"},
+		{http.StatusBadRequest, `{"errorCode":"ERROR_INVALID_PARAMETER","reason":"volume"}`, ErrBadRequest, "ERROR_INVALID_PARAMETER"},
+		{http.StatusTooManyRequests, `Too Many Requests`, ErrRateLimited, ""},
+		{statusHouseholdOffline, `{"errorCode":"ERROR_HOUSEHOLD_OFFLINE"}`, ErrPlayerOffline, "ERROR_HOUSEHOLD_OFFLINE"},
+		{http.StatusForbidden, ``, ErrUnauthorized, ""},
+		{http.StatusInternalServerError, `<html>error</html>`, nil, ""},
+	}
+	for _, test := range tests {
+		apiErr := newAPIError(test.status, []byte(test.body))
+		if apiErr.Code != test.code || apiErr.kind != test.kind {
+			t.Errorf("%d %s decoded as %q, kind %v", test.status, test.body, apiErr.Code, apiErr.kind)
+		}
+	}
+}
+
+func TestClient_ErrorResponse(t *testing.T) {
+	client, server := newCloudTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte(`{"errorCode":"ERROR_RESOURCE_GONE","reason":"group RINCON_A:1 not found"}`))
+	})
+	defer server.Close()
+
+	success, err := client.PlaybackSet(context.Background(), "play", "RINCON_A:1")
+	if success || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Command to a vanished group must fail, got %v, err: %v", success, err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusGone || apiErr.Reason != "group RINCON_A:1 not found" {
+		t.Fatalf("Unexpected error %#v", err)
+	}
+	if _, err := client.VolumeGet(context.Background(), "RINCON_A:1"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("Error must be returned by every method, got", err)
+	}
+}
@@ -1,21 +1,25 @@
 package sonos
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 )
 
-func (clt *Client) FavoritesGet(HouseholdID string) ([]Favorite, error) {
+func (clt *Client) FavoritesGet(ctx context.Context, HouseholdID string) ([]Favorite, error) {
 	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/households/", HouseholdID, "/favorites")
 
 	type FavoritesResponse struct {
-		Version   string `json:"version"`
+		Version   string     `json:"version"`
 		Favorites []Favorite `json:"items"`
 	}
 
-	body , err:= clt.doApiRequest(http.MethodGet,url,nil)
+	body, err := clt.doApiRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
 	var resp FavoritesResponse
 	err = json.Unmarshal(body, &resp)
 	if err != nil {
@@ -26,7 +30,7 @@ func (clt *Client) FavoritesGet(HouseholdID string) ([]Favorite, error) {
 
 }
 
-func (clt *Client) FavoriteSet(val string, id string) (bool, error) {
+func (clt *Client) FavoriteSet(ctx context.Context, val string, id string) (bool, error) {
 	url := fmt.Sprintf("%s%s%s", "https://api.ws.sonos.com/control/api/v1/groups/", id, "/favorites")
 
 	body := map[string]interface{}{
@@ -35,7 +39,10 @@ func (clt *Client) FavoriteSet(val string, id string) (bool, error) {
 		"playOnCompletion": true,
 	}
 
-	binResponse, err := clt.doApiRequest(http.MethodPost, url, body)
+	binResponse, err := clt.doApiRequest(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return false, err
+	}
 	var response map[string]string
 	err = json.Unmarshal(binResponse, &response)
 	if err != nil {
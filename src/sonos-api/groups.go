@@ -1,6 +1,7 @@
 package sonos
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -14,10 +15,13 @@ type GroupsAndPlayersResponse struct {
 	Groups  []Group  `json:"groups"`
 }
 
-func (clt *Client) GetGroupsAndPlayers(HouseholdID string) ([]Group, []Player, error) {
+func (clt *Client) GetGroupsAndPlayers(ctx context.Context, HouseholdID string) ([]Group, []Player, error) {
 	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/households/", HouseholdID, "/groups")
 
-	body, err := clt.doApiRequest(http.MethodGet, url, nil)
+	body, err := clt.doApiRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
 	var resp GroupsAndPlayersResponse
 
 	err = json.Unmarshal(body, &resp)
@@ -26,17 +30,41 @@ func (clt *Client) GetGroupsAndPlayers(HouseholdID string) ([]Group, []Player, e
 		return nil, nil, err
 	}
 	for i := 0; i < len(resp.Groups); i++ {
-		IDs := strings.Split(resp.Groups[i].GroupId, "_")[1]
-		resp.Groups[i].FimpId = strings.Split(IDs, ":")[0]
-		resp.Groups[i].OnlyGroupId = strings.Split(IDs, ":")[1]
+		setGroupIds(&resp.Groups[i])
+		resp.Groups[i].HouseholdId = HouseholdID
 	}
 	for i := 0; i < len(resp.Players); i++ {
 		resp.Players[i].FimpId = strings.Split(resp.Players[i].Id, "_")[1]
+		resp.Players[i].HouseholdId = HouseholdID
+	}
+	clt.limiter.SetTopology(HouseholdID, resp.Groups, resp.Players)
+	if local := clt.localTransport(); local != nil {
+		local.UpdateTopology(HouseholdID, resp.Groups, resp.Players)
 	}
 
 	return resp.Groups, resp.Players, nil
 }
 
+// RestoreTopology sets local routes and request budgets from groups and players saved before restart, so local households
+// are controllable before the cloud is reachable
+func (clt *Client) RestoreTopology(groups []Group, players []Player) {
+	householdGroups := map[string][]Group{}
+	householdPlayers := map[string][]Player{}
+	for _, group := range groups {
+		householdGroups[group.HouseholdId] = append(householdGroups[group.HouseholdId], group)
+	}
+	for _, player := range players {
+		householdPlayers[player.HouseholdId] = append(householdPlayers[player.HouseholdId], player)
+	}
+	local := clt.localTransport()
+	for householdID := range householdGroups {
+		clt.limiter.SetTopology(householdID, householdGroups[householdID], householdPlayers[householdID])
+		if local != nil {
+			local.UpdateTopology(householdID, householdGroups[householdID], householdPlayers[householdID])
+		}
+	}
+}
+
 // FindGroupFromPlayer finds the GroupID of the Group that contains the given Player
 func (clt *Client) FindGroupFromPlayer(playerID string, groups []Group) (string, error) {
 	// I have player ID from parameter.
@@ -50,6 +78,79 @@ func (clt *Client) FindGroupFromPlayer(playerID string, groups []Group) (string,
 			}
 		}
 	}
-	err := fmt.Errorf("could not find a group which contains player")
-	return "", err
+	return "", fmt.Errorf("%w: no group contains player %s", ErrNotFound, playerID)
+}
+
+// CreateGroup creates new group from the players. Players are removed from their current groups.
+func (clt *Client) CreateGroup(ctx context.Context, householdID string, playerIDs []string) (*Group, error) {
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/households/", householdID, "/groups/createGroup")
+	body := map[string]interface{}{
+		"playerIds": playerIDs,
+	}
+	return clt.doGroupRequest(ctx, url, body, householdID, "")
+}
+
+// ModifyGroupMembers adds and removes players to/from the group of the household
+func (clt *Client) ModifyGroupMembers(ctx context.Context, householdID, groupID string, playerIDsToAdd, playerIDsToRemove []string) (*Group, error) {
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/groups/", groupID, "/groups/modifyGroupMembers")
+	body := map[string]interface{}{
+		"playerIdsToAdd":    playerIDsToAdd,
+		"playerIdsToRemove": playerIDsToRemove,
+	}
+	return clt.doGroupRequest(ctx, url, body, householdID, groupID)
+}
+
+// SetGroupMembers replaces all members of the group of the household with the players
+func (clt *Client) SetGroupMembers(ctx context.Context, householdID, groupID string, playerIDs []string) (*Group, error) {
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/groups/", groupID, "/groups/setGroupMembers")
+	body := map[string]interface{}{
+		"playerIds": playerIDs,
+	}
+	return clt.doGroupRequest(ctx, url, body, householdID, groupID)
+}
+
+// doGroupRequest sends the group change. Both the changed group and the resulting group use the request budget of the household,
+// also when they are not in the last loaded topology yet.
+func (clt *Client) doGroupRequest(ctx context.Context, url string, body interface{}, householdID, groupID string) (*Group, error) {
+	if groupID != "" {
+		clt.limiter.SetTopology(householdID, []Group{{GroupId: groupID}}, nil)
+	}
+	binResponse, err := clt.doApiRequest(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Group Group `json:"group"`
+	}
+	err = json.Unmarshal(binResponse, &resp)
+	if err != nil {
+		log.Error("Can't unmarshal group body: ", err)
+		return nil, err
+	}
+	setGroupIds(&resp.Group)
+	resp.Group.HouseholdId = householdID
+	clt.limiter.SetTopology(householdID, []Group{resp.Group}, nil)
+	return &resp.Group, nil
+}
+
+// PlayerIdFromFimpId converts FIMP service address back to Sonos player id
+func PlayerIdFromFimpId(fimpID string) string {
+	return fmt.Sprintf("%s%s", "RINCON_", fimpID)
+}
+
+// FimpIdFromPlayerId converts Sonos player id to FIMP service address
+func FimpIdFromPlayerId(playerID string) string {
+	return strings.TrimPrefix(playerID, "RINCON_")
+}
+
+func setGroupIds(group *Group) {
+	parts := strings.SplitN(group.GroupId, "_", 2)
+	if len(parts) < 2 {
+		return
+	}
+	IDs := strings.Split(parts[1], ":")
+	group.FimpId = IDs[0]
+	if len(IDs) > 1 {
+		group.OnlyGroupId = IDs[1]
+	}
 }
@@ -0,0 +1,75 @@
+package sonos
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestClient_ModifyGroupMembers(t *testing.T) {
+	client, server := newRecordingClient(t)
+	defer server.Close()
+	server.respond("POST /groups/RINCON_A:11/groups/modifyGroupMembers", http.StatusOK, `{"group":{"id":"RINCON_A:12","coordinatorId":"RINCON_A","playerIds":["RINCON_A","RINCON_B"]}}`)
+
+	group, err := client.ModifyGroupMembers(context.Background(), "HH_1", "RINCON_A:11", []string{"RINCON_B"}, nil)
+	if err != nil {
+		t.Fatal("Can't modify group. Err:", err)
+	}
+	request := server.last(t)
+	if added, _ := request.body["playerIdsToAdd"].([]interface{}); len(added) != 1 || added[0] != "RINCON_B" {
+		t.Fatalf("Unexpected request %v", request.body)
+	}
+	if group.FimpId != "A" || group.OnlyGroupId != "12" || len(group.PlayersIds) != 2 || group.HouseholdId != "HH_1" {
+		t.Fatalf("Unexpected group %+v", group)
+	}
+	// requests of the changed and of the new group use the budget of the household
+	for _, groupID := range []string{"RINCON_A:11", "RINCON_A:12"} {
+		if household := client.limiter.householdOf("/control/api/v1/groups/" + groupID + "/playback"); household != "HH_1" {
+			t.Errorf("Group %s must use budget of its household, got %q", groupID, household)
+		}
+	}
+}
+
+func TestClient_CreateGroup(t *testing.T) {
+	client, server := newRecordingClient(t)
+	defer server.Close()
+	server.respond("POST /households/HH_1/groups/createGroup", http.StatusOK, `{"group":{"id":"RINCON_B:14","coordinatorId":"RINCON_B","playerIds":["RINCON_B","RINCON_C"]}}`)
+
+	group, err := client.CreateGroup(context.Background(), "HH_1", []string{"RINCON_B", "RINCON_C"})
+	if err != nil {
+		t.Fatal("Can't create group. Err:", err)
+	}
+	if players, _ := server.last(t).body["playerIds"].([]interface{}); len(players) != 2 {
+		t.Fatalf("Unexpected request %v", server.last(t).body)
+	}
+	if group.GroupId != "RINCON_B:14" || group.FimpId != "B" || group.HouseholdId != "HH_1" {
+		t.Fatalf("Unexpected group %+v", group)
+	}
+}
+
+func TestClient_SetGroupMembers(t *testing.T) {
+	client, server := newRecordingClient(t)
+	defer server.Close()
+	server.respond("POST /groups/RINCON_A:12/groups/setGroupMembers", http.StatusOK, `{"group":{"id":"RINCON_A:13","coordinatorId":"RINCON_A","playerIds":["RINCON_A","RINCON_C"]}}`)
+
+	group, err := client.SetGroupMembers(context.Background(), "HH_1", "RINCON_A:12", []string{"RINCON_A", "RINCON_C"})
+	if err != nil {
+		t.Fatal("Can't set group members. Err:", err)
+	}
+	if players, _ := server.last(t).body["playerIds"].([]interface{}); len(players) != 2 {
+		t.Fatalf("Unexpected request %v", server.last(t).body)
+	}
+	if group.GroupId != "RINCON_A:13" || len(group.PlayersIds) != 2 {
+		t.Fatalf("Unexpected group %+v", group)
+	}
+	if PlayerIdFromFimpId("C") != "RINCON_C" || FimpIdFromPlayerId("RINCON_C") != "C" {
+		t.Fatal("Player id conversion failed")
+	}
+
+	// players of other households can't be grouped
+	server.respond("POST /groups/RINCON_A:12/groups/setGroupMembers", http.StatusBadRequest, `{"errorCode":"ERROR_INVALID_PARAMETER"}`)
+	if group, err := client.SetGroupMembers(context.Background(), "HH_1", "RINCON_A:12", []string{"RINCON_X"}); group != nil || !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("Rejected change must be bad request error, got %+v %v", group, err)
+	}
+}
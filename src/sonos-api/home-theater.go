@@ -0,0 +1,64 @@
+package sonos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HomeTheaterOptions are soundbar settings. Only options which are not nil are changed by HomeTheaterOptionsSet.
+type HomeTheaterOptions struct {
+	NightMode     *bool `json:"nightMode,omitempty"`
+	EnhanceDialog *bool `json:"enhanceDialog,omitempty"`
+}
+
+// HomeTheaterOptionsGet returns night mode and speech enhancement settings of a player with HT_PLAYBACK capability
+func (clt *Client) HomeTheaterOptionsGet(ctx context.Context, playerId string) (*HomeTheaterOptions, error) {
+	var resp HomeTheaterOptions
+	if sent, err := clt.doLocalRequest(ctx, playerId, NamespaceHomeTheater, "getOptions", nil, &resp); sent {
+		if err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	}
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/players/", playerId, "/homeTheater/options")
+	body, err := clt.doApiRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		log.Error("<client>Can't unmarshalling HomeTheater response : ", err)
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// HomeTheaterOptionsSet changes night mode and/or speech enhancement
+func (clt *Client) HomeTheaterOptionsSet(ctx context.Context, options HomeTheaterOptions, playerId string) (bool, error) {
+	if sent, err := clt.doLocalRequest(ctx, playerId, NamespaceHomeTheater, "setOptions", options, nil); sent {
+		return err == nil, err
+	}
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/players/", playerId, "/homeTheater/options")
+	_, err := clt.doApiRequest(ctx, http.MethodPost, url, options)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// HomeTheaterLoad switches the player to TV input
+func (clt *Client) HomeTheaterLoad(ctx context.Context, playerId string) (bool, error) {
+	if sent, err := clt.doLocalRequest(ctx, playerId, NamespaceHomeTheater, "loadHomeTheaterPlayback", nil, nil); sent {
+		return err == nil, err
+	}
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/players/", playerId, "/homeTheater")
+	_, err := clt.doApiRequest(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,43 @@
+package sonos
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestClient_HomeTheaterOptions(t *testing.T) {
+	client, server := newRecordingClient(t)
+	defer server.Close()
+	server.respond("GET /players/RINCON_A/homeTheater/options", http.StatusOK, `{"nightMode":true,"enhanceDialog":false}`)
+
+	nightMode := true
+	if ok, err := client.HomeTheaterOptionsSet(context.Background(), HomeTheaterOptions{NightMode: &nightMode}, "RINCON_A"); !ok || err != nil {
+		t.Fatal("Can't set home theater options. Err:", err)
+	}
+	request := server.last(t)
+	if request.String() != "POST /players/RINCON_A/homeTheater/options" || request.body["nightMode"] != true {
+		t.Fatalf("Unexpected request %s %v", request, request.body)
+	}
+	if _, ok := request.body["enhanceDialog"]; ok {
+		t.Fatal("Unset option must not be sent")
+	}
+	options, err := client.HomeTheaterOptionsGet(context.Background(), "RINCON_A")
+	if err != nil {
+		t.Fatal("Can't get home theater options. Err:", err)
+	}
+	if options.NightMode == nil || !*options.NightMode || options.EnhanceDialog == nil || *options.EnhanceDialog {
+		t.Fatalf("Options must be read from the response, got %+v", options)
+	}
+
+	// players without HT_PLAYBACK reject home theater commands
+	server.respond("POST /players/RINCON_A/homeTheater", http.StatusBadRequest, `{"errorCode":"ERROR_UNSUPPORTED_NAMESPACE"}`)
+	if ok, err := client.HomeTheaterLoad(context.Background(), "RINCON_A"); ok || !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("Rejected command must be bad request error, got %v %v", ok, err)
+	}
+	player := Player{Capabilities: []interface{}{CapabilityPlayback, CapabilityHTPlayback}}
+	if !player.HasCapability(CapabilityHTPlayback) || player.HasCapability(CapabilityLineIn) {
+		t.Fatal("Unexpected capability check result")
+	}
+}
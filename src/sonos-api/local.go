@@ -0,0 +1,403 @@
+package sonos
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	localProtocol = "v1.api.smartspeaker.audio"
+	localOrigin   = "http://localhost/"
+	localTimeout  = 10 * time.Second
+
+	NamespacePlayback         = "playback:1"
+	NamespaceGroupVolume      = "groupVolume:1"
+	NamespacePlaybackMetadata = "playbackMetadata:1"
+	NamespaceGroups           = "groups:1"
+	NamespacePlayerVolume     = "playerVolume:1"
+	NamespaceHomeTheater      = "homeTheater:1"
+	NamespacePlaybackSession  = "playbackSession:1"
+)
+
+// errLocalUnavailable is returned when the command couldn't be sent to the player, so it is safe to send it over the cloud
+var errLocalUnavailable = errors.New("player not reachable over local connection")
+
+// EventNamespaces are group namespaces the adapter subscribes to for state updates
+var EventNamespaces = []string{NamespacePlayback, NamespacePlaybackMetadata, NamespaceGroupVolume, NamespaceGroups}
+
+// PlayerEventNamespaces are player namespaces the adapter subscribes to for state updates
+var PlayerEventNamespaces = []string{NamespacePlayerVolume}
+
+// localHeader is the first element of every message exchanged over the player WebSocket.
+type localHeader struct {
+	Namespace   string `json:"namespace"`
+	Command     string `json:"command,omitempty"`
+	Response    string `json:"response,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Name        string `json:"name,omitempty"`
+	HouseholdID string `json:"householdId,omitempty"`
+	GroupID     string `json:"groupId,omitempty"`
+	PlayerID    string `json:"playerId,omitempty"`
+	SessionID   string `json:"sessionId,omitempty"`
+	CmdID       string `json:"cmdId,omitempty"`
+	Success     *bool  `json:"success,omitempty"`
+}
+
+type localMessage struct {
+	header localHeader
+	body   json.RawMessage
+}
+
+// Event is a state change pushed by the player, Body holds namespace specific json
+// (PlaybackStatusResponse, PlaybackMetadataResponse, VolumeResponse or GroupsAndPlayersResponse).
+type Event struct {
+	Namespace   string
+	Type        string
+	HouseholdID string
+	GroupID     string
+	PlayerID    string
+	Body        json.RawMessage
+}
+
+type EventHandler func(evt Event)
+
+type localTarget struct {
+	householdID string
+	url         string
+	isPlayer    bool
+}
+
+// LocalTransport sends Control API commands directly to the group coordinator over the player WebSocket,
+// so speakers on the same LAN stay controllable while the hub has no internet.
+type LocalTransport struct {
+	apiKey     string
+	timeout    time.Duration
+	mux        sync.Mutex
+	households map[string]bool
+	targets    map[string]localTarget
+	conns      map[string]*localConn
+	cmdCounter uint64
+	onEvent    EventHandler
+	closed     bool
+}
+
+type localConn struct {
+	ws            *websocket.Conn
+	mux           sync.Mutex
+	pending       map[string]chan localMessage
+	subscriptions map[string]bool
+	done          chan struct{}
+}
+
+func NewLocalTransport(apiKey string, households []string) *LocalTransport {
+	lt := &LocalTransport{
+		apiKey:     apiKey,
+		timeout:    localTimeout,
+		households: make(map[string]bool),
+		targets:    make(map[string]localTarget),
+		conns:      make(map[string]*localConn),
+	}
+	for _, id := range households {
+		lt.households[id] = true
+	}
+	return lt
+}
+
+// IsEnabled returns true if local control is selected for the household
+func (lt *LocalTransport) IsEnabled(householdID string) bool {
+	lt.mux.Lock()
+	defer lt.mux.Unlock()
+	return lt.households[householdID]
+}
+
+// UpdateTopology maps every group of the household to the WebSocket url of its coordinator and every player to its own url
+func (lt *LocalTransport) UpdateTopology(householdID string, groups []Group, players []Player) {
+	lt.mux.Lock()
+	defer lt.mux.Unlock()
+	if !lt.households[householdID] {
+		return
+	}
+	for id, target := range lt.targets {
+		if target.householdID == householdID {
+			delete(lt.targets, id)
+		}
+	}
+	for _, group := range groups {
+		for _, player := range players {
+			if player.Id == group.CoordinatorId && player.WebSocketUrl != "" {
+				lt.targets[group.GroupId] = localTarget{householdID: householdID, url: player.WebSocketUrl}
+				break
+			}
+		}
+	}
+	for _, player := range players {
+		if player.WebSocketUrl != "" {
+			lt.targets[player.Id] = localTarget{householdID: householdID, url: player.WebSocketUrl, isPlayer: true}
+		}
+	}
+}
+
+// HasGroup returns true if the group or player can be reached over the local transport
+func (lt *LocalTransport) HasGroup(id string) bool {
+	lt.mux.Lock()
+	defer lt.mux.Unlock()
+	_, ok := lt.targets[id]
+	return ok
+}
+
+// SetEventHandler sets function which is invoked for every event pushed by players. Handler is called from connection read loop,
+// so it must not block.
+func (lt *LocalTransport) SetEventHandler(handler EventHandler) {
+	lt.mux.Lock()
+	lt.onEvent = handler
+	lt.mux.Unlock()
+}
+
+// Subscribe subscribes to events of the namespace for the group or player. Subscriptions are tracked per connection,
+// so calling it again after a reconnect renews the subscription.
+func (lt *LocalTransport) Subscribe(ctx context.Context, id, namespace string) error {
+	if lt.IsSubscribed(id, namespace) {
+		return nil
+	}
+	if err := lt.Command(ctx, id, namespace, "subscribe", nil, nil); err != nil {
+		return err
+	}
+	lt.mux.Lock()
+	defer lt.mux.Unlock()
+	if target, ok := lt.targets[id]; ok {
+		if conn, ok := lt.conns[target.url]; ok {
+			conn.mux.Lock()
+			conn.subscriptions[id+"/"+namespace] = true
+			conn.mux.Unlock()
+		}
+	}
+	return nil
+}
+
+// IsSubscribed returns true if the group or player has active subscription on the namespace
+func (lt *LocalTransport) IsSubscribed(id, namespace string) bool {
+	lt.mux.Lock()
+	defer lt.mux.Unlock()
+	target, ok := lt.targets[id]
+	if !ok {
+		return false
+	}
+	conn, ok := lt.conns[target.url]
+	if !ok {
+		return false
+	}
+	conn.mux.Lock()
+	defer conn.mux.Unlock()
+	return conn.subscriptions[id+"/"+namespace]
+}
+
+// Command sends a group or player command and decodes the response body into out (if not nil)
+func (lt *LocalTransport) Command(ctx context.Context, id, namespace, command string, body, out interface{}) error {
+	return lt.command(ctx, id, "", namespace, command, body, out)
+}
+
+// SessionCommand sends a playback session command over the connection of the group coordinator
+func (lt *LocalTransport) SessionCommand(ctx context.Context, groupID, sessionID, namespace, command string, body, out interface{}) error {
+	return lt.command(ctx, groupID, sessionID, namespace, command, body, out)
+}
+
+func (lt *LocalTransport) command(ctx context.Context, id, sessionID, namespace, command string, body, out interface{}) error {
+	lt.mux.Lock()
+	target, ok := lt.targets[id]
+	lt.mux.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: no local route to %s", errLocalUnavailable, id)
+	}
+	conn, err := lt.getConn(target.url)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errLocalUnavailable, err)
+	}
+	header := localHeader{
+		Namespace:   namespace,
+		Command:     command,
+		HouseholdID: target.householdID,
+		CmdID:       strconv.FormatUint(atomic.AddUint64(&lt.cmdCounter, 1), 10),
+	}
+	if sessionID != "" {
+		header.SessionID = sessionID
+	} else if target.isPlayer {
+		header.PlayerID = id
+	} else {
+		header.GroupID = id
+	}
+	if body == nil {
+		body = struct{}{}
+	}
+	resp, err := conn.request(ctx, header, body, lt.timeout)
+	if err != nil {
+		lt.dropConn(target.url, conn)
+		return err
+	}
+	if resp.header.Success != nil && !*resp.header.Success {
+		return fmt.Errorf("local command %s/%s failed : %w", namespace, command, newAPIError(0, resp.body))
+	}
+	if out != nil && len(resp.body) > 0 {
+		return json.Unmarshal(resp.body, out)
+	}
+	return nil
+}
+
+// isLocalUnavailable returns true if the command failed before it was sent to the player
+func isLocalUnavailable(err error) bool {
+	return errors.Is(err, errLocalUnavailable)
+}
+
+// Close closes all open player connections
+func (lt *LocalTransport) Close() {
+	lt.mux.Lock()
+	conns := lt.conns
+	lt.conns = make(map[string]*localConn)
+	lt.closed = true
+	lt.mux.Unlock()
+	for _, conn := range conns {
+		conn.ws.Close()
+	}
+}
+
+// getConn returns open connection to the player or connects. The player is dialed without holding the lock, so a player
+// which doesn't respond doesn't delay commands and events of other players.
+func (lt *LocalTransport) getConn(url string) (*localConn, error) {
+	lt.mux.Lock()
+	conn, ok := lt.conns[url]
+	lt.mux.Unlock()
+	if ok {
+		return conn, nil
+	}
+	config, err := websocket.NewConfig(url, localOrigin)
+	if err != nil {
+		return nil, err
+	}
+	config.Protocol = []string{localProtocol}
+	config.Header.Set("X-Sonos-Api-Key", lt.apiKey)
+	// players use self signed certificates
+	config.TlsConfig = &tls.Config{InsecureSkipVerify: true}
+	config.Dialer = &net.Dialer{Timeout: lt.timeout}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	lt.mux.Lock()
+	if lt.closed {
+		lt.mux.Unlock()
+		ws.Close()
+		return nil, fmt.Errorf("local transport is closed")
+	}
+	if existing, ok := lt.conns[url]; ok {
+		// connected by another command in the meantime
+		lt.mux.Unlock()
+		ws.Close()
+		return existing, nil
+	}
+	conn = &localConn{ws: ws, pending: make(map[string]chan localMessage), subscriptions: make(map[string]bool), done: make(chan struct{})}
+	lt.conns[url] = conn
+	lt.mux.Unlock()
+	log.Info("<local> Connected to player ", url)
+	go func() {
+		conn.readLoop(lt.dispatchEvent)
+		lt.dropConn(url, conn)
+	}()
+	return conn, nil
+}
+
+func (lt *LocalTransport) dropConn(url string, conn *localConn) {
+	lt.mux.Lock()
+	if lt.conns[url] == conn {
+		delete(lt.conns, url)
+	}
+	lt.mux.Unlock()
+	conn.ws.Close()
+}
+
+func (lt *LocalTransport) dispatchEvent(msg localMessage) {
+	lt.mux.Lock()
+	handler := lt.onEvent
+	lt.mux.Unlock()
+	if handler == nil {
+		return
+	}
+	handler(Event{
+		Namespace:   msg.header.Namespace,
+		Type:        msg.header.Type,
+		HouseholdID: msg.header.HouseholdID,
+		GroupID:     msg.header.GroupID,
+		PlayerID:    msg.header.PlayerID,
+		Body:        msg.body,
+	})
+}
+
+func (c *localConn) request(ctx context.Context, header localHeader, body interface{}, timeout time.Duration) (localMessage, error) {
+	respCh := make(chan localMessage, 1)
+	c.mux.Lock()
+	c.pending[header.CmdID] = respCh
+	c.mux.Unlock()
+	defer func() {
+		c.mux.Lock()
+		delete(c.pending, header.CmdID)
+		c.mux.Unlock()
+	}()
+
+	c.ws.SetWriteDeadline(time.Now().Add(timeout))
+	if err := websocket.JSON.Send(c.ws, []interface{}{header, body}); err != nil {
+		return localMessage{}, err
+	}
+	select {
+	case resp := <-respCh:
+		return resp, nil
+	case <-c.done:
+		return localMessage{}, fmt.Errorf("connection to player closed")
+	case <-ctx.Done():
+		return localMessage{}, ctx.Err()
+	case <-time.After(timeout):
+		return localMessage{}, fmt.Errorf("timeout waiting for response to %s/%s", header.Namespace, header.Command)
+	}
+}
+
+func (c *localConn) readLoop(onEvent func(msg localMessage)) {
+	defer close(c.done)
+	for {
+		var raw []json.RawMessage
+		if err := websocket.JSON.Receive(c.ws, &raw); err != nil {
+			log.Debug("<local> Player connection closed. Err:", err)
+			return
+		}
+		if len(raw) == 0 {
+			continue
+		}
+		var msg localMessage
+		if err := json.Unmarshal(raw[0], &msg.header); err != nil {
+			log.Debug("<local> Can't unmarshal message header. Err:", err)
+			continue
+		}
+		if len(raw) > 1 {
+			msg.body = raw[1]
+		}
+		if msg.header.CmdID == "" {
+			if msg.header.Type != "" {
+				onEvent(msg)
+			}
+			continue
+		}
+		c.mux.Lock()
+		respCh, ok := c.pending[msg.header.CmdID]
+		c.mux.Unlock()
+		if ok {
+			respCh <- msg
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package sonos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// fakePlayer is an in-process stand-in for the player WebSocket API
+type fakePlayer struct {
+	mux      sync.Mutex
+	server   *httptest.Server
+	commands []localHeader
+	bodies   []json.RawMessage
+	apiKey   string
+	fail     map[string]bool
+	replies  map[string]interface{}
+}
+
+func newFakePlayer() *fakePlayer {
+	fp := &fakePlayer{fail: map[string]bool{}, replies: map[string]interface{}{}}
+	fp.server = httptest.NewServer(websocket.Server{Handler: fp.handle})
+	return fp
+}
+
+func (fp *fakePlayer) url() string {
+	return "ws" + strings.TrimPrefix(fp.server.URL, "http") + "/websocket/api"
+}
+
+func (fp *fakePlayer) handle(ws *websocket.Conn) {
+	fp.mux.Lock()
+	fp.apiKey = ws.Request().Header.Get("X-Sonos-Api-Key")
+	fp.mux.Unlock()
+	for {
+		var raw []json.RawMessage
+		if err := websocket.JSON.Receive(ws, &raw); err != nil {
+			return
+		}
+		var header localHeader
+		json.Unmarshal(raw[0], &header)
+		fp.mux.Lock()
+		fp.commands = append(fp.commands, header)
+		fp.bodies = append(fp.bodies, raw[1])
+		success := !fp.fail[header.Command]
+		var body interface{} = struct{}{}
+		if reply, ok := fp.replies[header.Command]; ok {
+			body = reply
+		}
+		fp.mux.Unlock()
+		if !success {
+			body = map[string]string{"errorCode": "ERROR_COMMAND_FAILED"}
+		}
+		resp := localHeader{Namespace: header.Namespace, Response: header.Command, HouseholdID: header.HouseholdID,
+			GroupID: header.GroupID, CmdID: header.CmdID, Success: &success}
+		websocket.JSON.Send(ws, []interface{}{resp, body})
+		if header.Command == "subscribe" && header.Namespace == NamespaceGroupVolume {
+			evt := localHeader{Namespace: header.Namespace, Type: "groupVolume", HouseholdID: header.HouseholdID, GroupID: header.GroupID}
+			websocket.JSON.Send(ws, []interface{}{evt, VolumeResponse{Volume: 17}})
+		}
+	}
+}
+
+func newLocalTestClient(fp *fakePlayer) *Client {
+	client := NewClient("beta", "", "")
+	client.EnableLocalControl("test-key", []string{"HH_1"})
+	groups := []Group{{GroupId: "RINCON_A:1", CoordinatorId: "RINCON_A"}}
+	players := []Player{{Id: "RINCON_A", WebSocketUrl: fp.url()}}
+	client.local.UpdateTopology("HH_1", groups, players)
+	return client
+}
+
+func TestLocalTransport_VolumeGet(t *testing.T) {
+	fp := newFakePlayer()
+	defer fp.server.Close()
+	fp.replies["getVolume"] = VolumeResponse{Volume: 42, Muted: true}
+	client := newLocalTestClient(fp)
+	defer client.local.Close()
+
+	vol, err := client.VolumeGet(context.Background(), "RINCON_A:1")
+	if err != nil {
+		t.Fatal("Can't get volume. Err:", err)
+	}
+	if vol.Volume != 42 || !vol.Muted {
+		t.Fatalf("Unexpected volume response %+v", vol)
+	}
+	if fp.apiKey != "test-key" {
+		t.Fatal("Api key header is not set")
+	}
+	cmd := fp.commands[0]
+	if cmd.Namespace != NamespaceGroupVolume || cmd.HouseholdID != "HH_1" || cmd.GroupID != "RINCON_A:1" {
+		t.Fatalf("Unexpected command header %+v", cmd)
+	}
+}
+
+func TestLocalTransport_PlaybackSet(t *testing.T) {
+	fp := newFakePlayer()
+	defer fp.server.Close()
+	client := newLocalTestClient(fp)
+	defer client.local.Close()
+
+	for _, val := range []string{"play", "next_track", "toggle_play_pause"} {
+		if _, err := client.PlaybackSet(context.Background(), val, "RINCON_A:1"); err != nil {
+			t.Fatal("Can't set playback. Err:", err)
+		}
+	}
+	expected := []string{"play", "skipToNextTrack", "togglePlayPause"}
+	if len(fp.commands) != len(expected) {
+		t.Fatalf("Expected %d commands, got %d", len(expected), len(fp.commands))
+	}
+	for i := range expected {
+		if fp.commands[i].Command != expected[i] || fp.commands[i].Namespace != NamespacePlayback {
+			t.Fatalf("Unexpected command %+v", fp.commands[i])
+		}
+	}
+}
+
+func TestLocalTransport_CommandFailure(t *testing.T) {
+	fp := newFakePlayer()
+	defer fp.server.Close()
+	fp.fail["setVolume"] = true
+	client := newLocalTestClient(fp)
+	defer client.local.Close()
+
+	err := client.local.Command(context.Background(), "RINCON_A:1", NamespaceGroupVolume, "setVolume", map[string]int{"volume": 10}, nil)
+	if err == nil {
+		t.Fatal("Expected error on failed command")
+	}
+	if client.local.HasGroup("RINCON_B:1") {
+		t.Fatal("Unknown group must not have local route")
+	}
+}
+
+func TestLocalTransport_HouseholdSelection(t *testing.T) {
+	lt := NewLocalTransport("key", []string{"HH_1"})
+	groups := []Group{{GroupId: "RINCON_A:1", CoordinatorId: "RINCON_A"}}
+	players := []Player{{Id: "RINCON_A", WebSocketUrl: "wss://127.0.0.1:1443/websocket/api"}}
+	lt.UpdateTopology("HH_2", groups, players)
+	if lt.HasGroup("RINCON_A:1") {
+		t.Fatal("Group from household without local control must use cloud")
+	}
+	lt.UpdateTopology("HH_1", groups, players)
+	if !lt.HasGroup("RINCON_A:1") {
+		t.Fatal("Group from local household must be routed locally")
+	}
+}
+
+func TestLocalTransport_Events(t *testing.T) {
+	fp := newFakePlayer()
+	defer fp.server.Close()
+	client := newLocalTestClient(fp)
+	defer client.local.Close()
+
+	events := make(chan Event, 5)
+	client.SetEventHandler(func(evt Event) {
+		events <- evt
+	})
+	groups := []Group{{GroupId: "RINCON_A:1"}}
+	if client.HasEventSubscriptions(groups, nil) {
+		t.Fatal("Group must not be subscribed before SubscribeEvents")
+	}
+	if err := client.SubscribeEvents(context.Background(), "RINCON_A:1"); err != nil {
+		t.Fatal("Can't subscribe. Err:", err)
+	}
+	if !client.HasEventSubscriptions(groups, nil) {
+		t.Fatal("Group must be subscribed")
+	}
+	select {
+	case evt := <-events:
+		var volume VolumeResponse
+		json.Unmarshal(evt.Body, &volume)
+		if evt.Namespace != NamespaceGroupVolume || evt.GroupID != "RINCON_A:1" || volume.Volume != 17 {
+			t.Fatalf("Unexpected event %+v", evt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Event not received")
+	}
+	if err := client.SubscribeEvents(context.Background(), "RINCON_B:1"); err == nil {
+		t.Fatal("Subscribing group without local route must fail")
+	}
+}
+
+func TestClient_LocalFallback(t *testing.T) {
+	fp := newFakePlayer()
+	defer fp.server.Close()
+	fp.fail["togglePlayPause"] = true
+	var cloudRequests int32
+	client, server := newCloudTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&cloudRequests, 1)
+		w.Write([]byte(`{}`))
+	})
+	defer server.Close()
+	client.EnableLocalControl("test-key", []string{"HH_1"})
+	groups := []Group{{GroupId: "RINCON_A:1", CoordinatorId: "RINCON_A"}, {GroupId: "RINCON_B:1", CoordinatorId: "RINCON_B"}}
+	players := []Player{{Id: "RINCON_A", WebSocketUrl: fp.url()}, {Id: "RINCON_B", WebSocketUrl: "ws://127.0.0.1:1/websocket/api"}}
+	client.local.UpdateTopology("HH_1", groups, players)
+	defer client.local.Close()
+
+	// the player rejected the command, sending it again over the cloud would toggle twice
+	_, err := client.PlaybackSet(context.Background(), "toggle_play_pause", "RINCON_A:1")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "ERROR_COMMAND_FAILED" || atomic.LoadInt32(&cloudRequests) != 0 {
+		t.Fatalf("Rejected local command must not be sent over cloud, got %d cloud requests, err: %v", cloudRequests, err)
+	}
+	// the player can't be connected, so the command wasn't executed
+	if _, err := client.PlaybackSet(context.Background(), "toggle_play_pause", "RINCON_B:1"); err != nil || atomic.LoadInt32(&cloudRequests) != 1 {
+		t.Fatalf("Command must fall back to cloud, got %d cloud requests, err: %v", cloudRequests, err)
+	}
+}
+
+func TestClient_EnableLocalControlWhileRunning(t *testing.T) {
+	client, server := newCloudTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"volume": 20}`))
+	})
+	defer server.Close()
+	groups := []Group{{GroupId: "RINCON_A:1", CoordinatorId: "RINCON_A", HouseholdId: "HH_1"}}
+	players := []Player{{Id: "RINCON_A", HouseholdId: "HH_1", WebSocketUrl: "ws://127.0.0.1:1/websocket/api"}}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			client.EnableLocalControl("test-key", []string{"HH_1"})
+			client.RestoreTopology(groups, players)
+			client.EnableLocalControl("", nil)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := client.VolumeGet(context.Background(), "RINCON_A:1"); err != nil {
+			t.Fatal("Command must not fail while local control is changed, err:", err)
+		}
+		client.HasEventSubscriptions(groups, players)
+	}
+	wg.Wait()
+}
+
+func TestLocalTransport_SlowDialDoesNotBlock(t *testing.T) {
+	fp := newFakePlayer()
+	defer fp.server.Close()
+	// accepts connections but never completes the WebSocket handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	lt := NewLocalTransport("test-key", []string{"HH_1"})
+	defer lt.Close()
+	groups := []Group{{GroupId: "RINCON_A:1", CoordinatorId: "RINCON_A"}, {GroupId: "RINCON_B:1", CoordinatorId: "RINCON_B"}}
+	players := []Player{{Id: "RINCON_A", WebSocketUrl: fp.url()}, {Id: "RINCON_B", WebSocketUrl: "ws://" + listener.Addr().String() + "/websocket/api"}}
+	lt.UpdateTopology("HH_1", groups, players)
+
+	go lt.Command(context.Background(), "RINCON_B:1", NamespacePlayback, "play", nil, nil)
+	conn := <-accepted
+	defer conn.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- lt.Command(context.Background(), "RINCON_A:1", NamespacePlayback, "play", nil, nil)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal("Command failed. Err:", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Command to another player must not wait for the dial")
+	}
+}
@@ -0,0 +1,102 @@
+package sonos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const appID = "futurehome.edge.app"
+
+// PlaybackSession is the session of the adapter on a group, content loaded into a session replaces current group content
+type PlaybackSession struct {
+	SessionID      string `json:"sessionId"`
+	SessionState   string `json:"sessionState"`
+	SessionCreated bool   `json:"sessionCreated"`
+	groupId        string
+	local          bool // created over local transport, session ids are valid only on the transport which created them
+}
+
+// StationMetadata is displayed in Sonos app while stream is playing
+type StationMetadata struct {
+	Name     string `json:"name,omitempty"`
+	ImageURL string `json:"imageUrl,omitempty"`
+}
+
+func joinSessionBody() map[string]interface{} {
+	return map[string]interface{}{
+		"appId":      appID,
+		"appContext": "stream",
+	}
+}
+
+// PlaybackSessionJoinOrCreate joins existing session of the adapter on the group or creates a new one
+func (clt *Client) PlaybackSessionJoinOrCreate(ctx context.Context, groupId string) (*PlaybackSession, error) {
+	resp := PlaybackSession{groupId: groupId, local: true}
+	if sent, err := clt.doLocalRequest(ctx, groupId, NamespacePlaybackSession, "joinOrCreateSession", joinSessionBody(), &resp); sent {
+		if err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	}
+	return clt.cloudSessionJoinOrCreate(ctx, groupId)
+}
+
+// cloudSessionJoinOrCreate joins or creates the session over the cloud API
+func (clt *Client) cloudSessionJoinOrCreate(ctx context.Context, groupId string) (*PlaybackSession, error) {
+	resp := PlaybackSession{groupId: groupId}
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/groups/", groupId, "/playbackSession/joinOrCreate")
+	binResponse, err := clt.doApiRequest(ctx, http.MethodPost, url, joinSessionBody())
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(binResponse, &resp)
+	if err != nil {
+		log.Error("<client>Can't unmarshalling playback session response : ", err)
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// LoadStreamUrl plays stream url in the session. The stream is loaded over the transport which created the session,
+// if the player can't be reached locally anymore a new session is created over the cloud.
+func (clt *Client) LoadStreamUrl(ctx context.Context, streamUrl string, metadata StationMetadata, session *PlaybackSession) (bool, error) {
+	body := map[string]interface{}{
+		"streamUrl":        streamUrl,
+		"playOnCompletion": true,
+		"stationMetadata":  metadata,
+	}
+	if session.local {
+		var err error
+		if local := clt.localTransport(); local != nil {
+			err = local.SessionCommand(ctx, session.groupId, session.SessionID, NamespacePlaybackSession, "loadStreamUrl", body, nil)
+		} else {
+			err = fmt.Errorf("%w: local control is disabled", errLocalUnavailable)
+		}
+		if !isLocalUnavailable(err) {
+			return err == nil, err
+		}
+		log.Warn("<client> Local loadStreamUrl failed, creating cloud session. Err:", err.Error())
+		if session, err = clt.cloudSessionJoinOrCreate(ctx, session.groupId); err != nil {
+			return false, err
+		}
+	}
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/playbackSessions/", session.SessionID, "/playbackSession/loadStreamUrl")
+	_, err := clt.doApiRequest(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PlayStreamUrl joins or creates playback session on the group and starts the stream
+func (clt *Client) PlayStreamUrl(ctx context.Context, streamUrl string, metadata StationMetadata, groupId string) (bool, error) {
+	session, err := clt.PlaybackSessionJoinOrCreate(ctx, groupId)
+	if err != nil {
+		return false, err
+	}
+	return clt.LoadStreamUrl(ctx, streamUrl, metadata, session)
+}
@@ -0,0 +1,59 @@
+package sonos
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestClient_PlayStreamUrl(t *testing.T) {
+	client, server := newRecordingClient(t)
+	defer server.Close()
+	server.respond("POST /groups/RINCON_A:1/playbackSession/joinOrCreate", http.StatusOK, `{"sessionId":"SESSION_1","sessionState":"SESSION_STATE_CONNECTED","sessionCreated":true}`)
+
+	if ok, err := client.PlayStreamUrl(context.Background(), "http://radio/stream.mp3", StationMetadata{Name: "Radio"}, "RINCON_A:1"); !ok || err != nil {
+		t.Fatal("Can't play stream. Err:", err)
+	}
+	requests := server.requests()
+	// the stream is loaded into the session returned by joinOrCreate
+	if len(requests) != 2 || requests[0].String() != "POST /groups/RINCON_A:1/playbackSession/joinOrCreate" ||
+		requests[1].String() != "POST /playbackSessions/SESSION_1/playbackSession/loadStreamUrl" {
+		t.Fatalf("Unexpected requests %v", requests)
+	}
+	station, _ := requests[1].body["stationMetadata"].(map[string]interface{})
+	if requests[1].body["streamUrl"] != "http://radio/stream.mp3" || station["name"] != "Radio" {
+		t.Fatalf("Unexpected request %v", requests[1].body)
+	}
+
+	// no session, nothing is loaded
+	server.respond("POST /groups/RINCON_A:1/playbackSession/joinOrCreate", http.StatusGone, `{"errorCode":"ERROR_RESOURCE_GONE"}`)
+	if ok, err := client.PlayStreamUrl(context.Background(), "http://radio/stream.mp3", StationMetadata{}, "RINCON_A:1"); ok || err == nil || len(server.requests()) != 3 {
+		t.Fatalf("Stream must not be loaded without session, got %v %v", ok, err)
+	}
+}
+
+func TestClient_LoadStreamUrlLocalSessionFallback(t *testing.T) {
+	fp := newFakePlayer()
+	defer fp.server.Close()
+	fp.replies["joinOrCreateSession"] = PlaybackSession{SessionID: "LOCAL_1"}
+	client, server := newRecordingClient(t)
+	defer server.Close()
+	server.respond("POST /groups/RINCON_A:1/playbackSession/joinOrCreate", http.StatusOK, `{"sessionId":"CLOUD_1"}`)
+	client.EnableLocalControl("test-key", []string{"HH_1"})
+	client.localTransport().UpdateTopology("HH_1", []Group{{GroupId: "RINCON_A:1", CoordinatorId: "RINCON_A"}}, []Player{{Id: "RINCON_A", WebSocketUrl: fp.url()}})
+
+	session, err := client.PlaybackSessionJoinOrCreate(context.Background(), "RINCON_A:1")
+	if err != nil || session.SessionID != "LOCAL_1" {
+		t.Fatalf("Session must be created locally, got %+v, err: %v", session, err)
+	}
+	// local session id is unknown to the cloud, a cloud session must be created
+	client.EnableLocalControl("", nil)
+	if _, err := client.LoadStreamUrl(context.Background(), "http://radio/stream.mp3", StationMetadata{}, session); err != nil {
+		t.Fatal("Can't load stream. Err:", err)
+	}
+	requests := server.requests()
+	if len(requests) != 2 || requests[0].String() != "POST /groups/RINCON_A:1/playbackSession/joinOrCreate" ||
+		requests[1].String() != "POST /playbackSessions/CLOUD_1/playbackSession/loadStreamUrl" {
+		t.Fatalf("Unexpected requests %v", requests)
+	}
+}
@@ -1,6 +1,7 @@
 package sonos
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
@@ -18,15 +19,18 @@ type PlaybackStatusResponse struct {
 		Crossfade bool `json:"crossfade"`
 		Shuffle   bool `json:"shuffle"`
 	} `json:"playModes"`
-	AvailablePlaybackActions struct {
-		CanSkip      bool `json:"canSkip"`
-		CanSkipBack  bool `json:"canSkipBack"`
-		CanSeek      bool `json:"canSeek"`
-		CanRepeat    bool `json:"canRepeat"`
-		CanRepeatOne bool `json:"canRepeatOne"`
-		CanCrossfade bool `json:"canCrossfade"`
-		CanShuffle   bool `json:"canShuffle"`
-	} `json:"availablePlaybackActions"`
+	AvailablePlaybackActions PlaybackActions `json:"availablePlaybackActions"`
+}
+
+// PlaybackActions tells which actions are allowed by the current source, e.g. radio streams can't skip
+type PlaybackActions struct {
+	CanSkip      bool `json:"canSkip"`
+	CanSkipBack  bool `json:"canSkipBack"`
+	CanSeek      bool `json:"canSeek"`
+	CanRepeat    bool `json:"canRepeat"`
+	CanRepeatOne bool `json:"canRepeatOne"`
+	CanCrossfade bool `json:"canCrossfade"`
+	CanShuffle   bool `json:"canShuffle"`
 }
 
 type PlaybackMetadataResponse struct {
@@ -37,11 +41,20 @@ type PlaybackMetadataResponse struct {
 }
 
 // GetPlaybackStatus gets playback status
-func (clt *Client) PlaybackGetStatus(id string) (*PlaybackStatusResponse, error) {
+func (clt *Client) PlaybackGetStatus(ctx context.Context, id string) (*PlaybackStatusResponse, error) {
+	var resp PlaybackStatusResponse
+	if sent, err := clt.doLocalRequest(ctx, id, NamespacePlayback, "getPlaybackStatus", nil, &resp); sent {
+		if err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	}
 	url := fmt.Sprintf("%s%s%s", "https://api.ws.sonos.com/control/api/v1/groups/", id, "/playback")
 
-	body, err := clt.doApiRequest(http.MethodGet, url, nil)
-	var resp PlaybackStatusResponse
+	body, err := clt.doApiRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
 	err = json.Unmarshal(body, &resp)
 	if err != nil {
 		log.Error("<client>Can't unmarshalling Favorites response : ", err)
@@ -51,7 +64,7 @@ func (clt *Client) PlaybackGetStatus(id string) (*PlaybackStatusResponse, error)
 }
 
 // PlaybackSet sends request to Sonos to play, pause or skip. Id is group id
-func (clt *Client) PlaybackSet(val string, id string) (bool, error) {
+func (clt *Client) PlaybackSet(ctx context.Context, val string, id string) (bool, error) {
 	// change toggle_play_pause to togglePlayPause, next_track to skipToNextTrack and previous_track to skipToPreviousTrack
 	if val == "toggle_play_pause" {
 		val = "togglePlayPause"
@@ -60,10 +73,16 @@ func (clt *Client) PlaybackSet(val string, id string) (bool, error) {
 	} else if val == "previous_track" {
 		val = "skipToPreviousTrack"
 	}
+	if sent, err := clt.doLocalRequest(ctx, id, NamespacePlayback, val, nil, nil); sent {
+		return err == nil, err
+	}
 
 	url := fmt.Sprintf("%s%s%s%s", "https://api.ws.sonos.com/control/api/v1/groups/", id, "/playback/", val)
 
-	binResponse, err := clt.doApiRequest(http.MethodPost, url, nil)
+	binResponse, err := clt.doApiRequest(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return false, err
+	}
 	var response interface{}
 	err = json.Unmarshal(binResponse, &response)
 	if err != nil {
@@ -75,7 +94,7 @@ func (clt *Client) PlaybackSet(val string, id string) (bool, error) {
 }
 
 // ModeSet sets new play mode
-func (clt *Client) PlaybackModeSet(val map[string]bool, id string) (bool, error) {
+func (clt *Client) PlaybackModeSet(ctx context.Context, val map[string]bool, id string) (bool, error) {
 	url := fmt.Sprintf("%s%s%s", "https://api.ws.sonos.com/control/api/v1/groups/", id, "/playback/playMode")
 	// if one of the modes is repeat_one, change to repeatOne
 
@@ -87,8 +106,14 @@ func (clt *Client) PlaybackModeSet(val map[string]bool, id string) (bool, error)
 	mode := map[string]interface{}{
 		"playModes": val,
 	}
+	if sent, err := clt.doLocalRequest(ctx, id, NamespacePlayback, "setPlayModes", mode, nil); sent {
+		return err == nil, err
+	}
 
-	binResponse, err := clt.doApiRequest(http.MethodPost, url, mode)
+	binResponse, err := clt.doApiRequest(ctx, http.MethodPost, url, mode)
+	if err != nil {
+		return false, err
+	}
 	var response interface{}
 	err = json.Unmarshal(binResponse, &response)
 	if err != nil {
@@ -100,10 +125,19 @@ func (clt *Client) PlaybackModeSet(val map[string]bool, id string) (bool, error)
 
 }
 
-func (clt *Client) GetMetadata(groupID string) (*PlaybackMetadataResponse, error) {
-	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/groups/", groupID, "/playbackMetadata")
-	body, err := clt.doApiRequest(http.MethodGet, url, nil)
+func (clt *Client) GetMetadata(ctx context.Context, groupID string) (*PlaybackMetadataResponse, error) {
 	var resp PlaybackMetadataResponse
+	if sent, err := clt.doLocalRequest(ctx, groupID, NamespacePlaybackMetadata, "getMetadataStatus", nil, &resp); sent {
+		if err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	}
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/groups/", groupID, "/playbackMetadata")
+	body, err := clt.doApiRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
 	err = json.Unmarshal(body, &resp)
 	if err != nil {
 		log.Error("<client>Can't unmarshalling Favorites response : ", err)
@@ -111,3 +145,53 @@ func (clt *Client) GetMetadata(groupID string) (*PlaybackMetadataResponse, error
 	}
 	return &resp, nil
 }
+
+// PlaybackSeek moves to the absolute position within the current track
+func (clt *Client) PlaybackSeek(ctx context.Context, positionMillis int64, id string) (bool, error) {
+	body := map[string]interface{}{
+		"positionMillis": positionMillis,
+	}
+	if sent, err := clt.doLocalRequest(ctx, id, NamespacePlayback, "seek", body, nil); sent {
+		return err == nil, err
+	}
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/groups/", id, "/playback/seek")
+	_, err := clt.doApiRequest(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PlaybackSeekRelative moves forward or backward (negative delta) within the current track
+func (clt *Client) PlaybackSeekRelative(ctx context.Context, deltaMillis int64, id string) (bool, error) {
+	body := map[string]interface{}{
+		"deltaMillis": deltaMillis,
+	}
+	if sent, err := clt.doLocalRequest(ctx, id, NamespacePlayback, "seekRelative", body, nil); sent {
+		return err == nil, err
+	}
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/groups/", id, "/playback/seekRelative")
+	_, err := clt.doApiRequest(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// LoadLineIn switches the group to line-in input of the device. deviceId is the id of the player with LINE_IN capability,
+// it can be a player outside of the group.
+func (clt *Client) LoadLineIn(ctx context.Context, deviceId string, playOnCompletion bool, id string) (bool, error) {
+	body := map[string]interface{}{
+		"deviceId":         deviceId,
+		"playOnCompletion": playOnCompletion,
+	}
+	if sent, err := clt.doLocalRequest(ctx, id, NamespacePlayback, "loadLineIn", body, nil); sent {
+		return err == nil, err
+	}
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/groups/", id, "/playback/lineIn")
+	_, err := clt.doApiRequest(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
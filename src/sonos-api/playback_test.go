@@ -0,0 +1,54 @@
+package sonos
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestClient_PlaybackSeek(t *testing.T) {
+	client, server := newRecordingClient(t)
+	defer server.Close()
+
+	if ok, err := client.PlaybackSeek(context.Background(), 60000, "RINCON_A:1"); !ok || err != nil {
+		t.Fatal("Can't seek. Err:", err)
+	}
+	if request := server.last(t); request.String() != "POST /groups/RINCON_A:1/playback/seek" || request.body["positionMillis"] != float64(60000) {
+		t.Fatalf("Unexpected request %s %v", request, request.body)
+	}
+	if ok, err := client.PlaybackSeekRelative(context.Background(), -30000, "RINCON_A:1"); !ok || err != nil {
+		t.Fatal("Can't seek. Err:", err)
+	}
+	if request := server.last(t); request.String() != "POST /groups/RINCON_A:1/playback/seekRelative" || request.body["deltaMillis"] != float64(-30000) {
+		t.Fatalf("Unexpected request %s %v", request, request.body)
+	}
+
+	// radio can't seek
+	server.respond("POST /groups/RINCON_A:1/playback/seek", http.StatusBadRequest, `{"errorCode":"ERROR_UNSUPPORTED_COMMAND"}`)
+	if ok, err := client.PlaybackSeek(context.Background(), 1000, "RINCON_A:1"); ok || !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("Rejected seek must be bad request error, got %v %v", ok, err)
+	}
+}
+
+func TestClient_LoadLineIn(t *testing.T) {
+	client, server := newRecordingClient(t)
+	defer server.Close()
+
+	if ok, err := client.LoadLineIn(context.Background(), "RINCON_B", true, "RINCON_A:1"); !ok || err != nil {
+		t.Fatal("Can't load line-in. Err:", err)
+	}
+	request := server.last(t)
+	if request.String() != "POST /groups/RINCON_A:1/playback/lineIn" {
+		t.Fatal("Unexpected request ", request)
+	}
+	if request.body["deviceId"] != "RINCON_B" || request.body["playOnCompletion"] != true {
+		t.Fatalf("Unexpected request %v", request.body)
+	}
+
+	// the group is gone after regrouping
+	server.respond("POST /groups/RINCON_A:1/playback/lineIn", http.StatusGone, `{"errorCode":"ERROR_RESOURCE_GONE"}`)
+	if ok, err := client.LoadLineIn(context.Background(), "RINCON_B", true, "RINCON_A:1"); ok || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Missing group must be not found error, got %v %v", ok, err)
+	}
+}
@@ -1,20 +1,24 @@
 package sonos
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 )
 
-func (clt *Client) PlaylistsGet( HouseholdID string) ([]Playlist, error) {
+func (clt *Client) PlaylistsGet(ctx context.Context, HouseholdID string) ([]Playlist, error) {
 	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/households/", HouseholdID, "/playlists")
 
 	type PlaylistResponse struct {
 		Playlists []Playlist `json:"playlists"`
 	}
 
-	body , err:= clt.doApiRequest(http.MethodGet,url,nil)
+	body, err := clt.doApiRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
 	var resp PlaylistResponse
 	err = json.Unmarshal(body, &resp)
 	if err != nil {
@@ -24,7 +28,7 @@ func (clt *Client) PlaylistsGet( HouseholdID string) ([]Playlist, error) {
 	return resp.Playlists, nil
 }
 
-func (clt *Client) PlaylistSet(val string, id string) (bool, error) {
+func (clt *Client) PlaylistSet(ctx context.Context, val string, id string) (bool, error) {
 	url := fmt.Sprintf("%s%s%s", "https://api.ws.sonos.com/control/api/v1/groups/", id, "/playlists")
 
 	body := map[string]interface{}{
@@ -33,7 +37,10 @@ func (clt *Client) PlaylistSet(val string, id string) (bool, error) {
 		"playOnCompletion": true,
 	}
 
-	binResponse, err := clt.doApiRequest(http.MethodPost, url, body)
+	binResponse, err := clt.doApiRequest(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return false, err
+	}
 	var response map[string]string
 	err = json.Unmarshal(binResponse, &response)
 	if err != nil {
@@ -43,4 +50,4 @@ func (clt *Client) PlaylistSet(val string, id string) (bool, error) {
 	// TODO : Check response
 
 	return true, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,193 @@
+package sonos
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// householdRequestBudget is the number of cloud requests per household per budgetInterval, unused budget is not
+	// saved for later beyond one interval
+	householdRequestBudget = 100
+	budgetInterval         = time.Minute
+	// maxRequestWait is how long a request waits for budget or for the end of a pause, longer waits fail right away
+	maxRequestWait = 5 * time.Second
+	// requestRetries is the number of retries of GET requests after network and server errors, of requests rejected with
+	// 401 and of requests rejected with 429 if Retry-After is within maxRequestWait
+	requestRetries = 2
+	// backoffBase is the pause before the first retry after a network or server error, it doubles with every retry
+	backoffBase = time.Second
+	// defaultRetryAfter is used when 429 response has no Retry-After header
+	defaultRetryAfter = 30 * time.Second
+)
+
+// householdLimit is the request budget of one household
+type householdLimit struct {
+	tokens      float64
+	updatedAt   time.Time
+	pausedUntil time.Time // no requests are sent until then, set by 429
+	deniedUntil time.Time // set when a request is rejected because the budget is used up
+}
+
+// rateLimiter keeps request budget of every household and pauses requests after 429. Network and server errors only
+// delay retries of the failed request, one unresponsive group doesn't block commands of the other groups.
+type rateLimiter struct {
+	mu         sync.Mutex
+	households map[string]*householdLimit
+	ids        map[string]string // group and player ids to household id
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{households: make(map[string]*householdLimit), ids: make(map[string]string)}
+}
+
+// SetTopology maps group and player ids of the household, so their requests use the budget of the household
+func (l *rateLimiter) SetTopology(householdID string, groups []Group, players []Player) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, group := range groups {
+		l.ids[group.GroupId] = householdID
+	}
+	for _, player := range players {
+		l.ids[player.Id] = householdID
+	}
+}
+
+// householdOf returns household of the request path, e.g. /control/api/v1/groups/RINCON_A:1/playback. Requests which
+// can't be assigned to a household, like /v1/households, share one budget.
+func (l *rateLimiter) householdOf(path string) string {
+	parts := strings.Split(path, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		switch parts[i] {
+		case "households":
+			return parts[i+1]
+		case "groups", "players":
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			return l.ids[parts[i+1]]
+		}
+	}
+	return ""
+}
+
+func (l *rateLimiter) household(householdID string) *householdLimit {
+	limit, ok := l.households[householdID]
+	if !ok {
+		limit = &householdLimit{tokens: float64(householdRequestBudget), updatedAt: time.Now()}
+		l.households[householdID] = limit
+	}
+	return limit
+}
+
+// Wait blocks until the request can be sent or ctx is done. It fails right away if the household is paused or out of budget
+// for longer than maxRequestWait. A request canceled while waiting doesn't use the budget.
+func (l *rateLimiter) Wait(ctx context.Context, householdID string) error {
+	l.mu.Lock()
+	limit := l.household(householdID)
+	now := time.Now()
+	var wait time.Duration
+	if now.Before(limit.pausedUntil) {
+		wait = limit.pausedUntil.Sub(now)
+		if wait > maxRequestWait {
+			l.mu.Unlock()
+			return &APIError{StatusCode: http.StatusTooManyRequests, Reason: fmt.Sprintf("rate limited by Sonos, retry in %s", wait.Round(time.Second)), kind: ErrRateLimited}
+		}
+	}
+	// token bucket, a request takes one token, tokens below zero are reserved by waiting requests
+	perToken := budgetInterval / time.Duration(householdRequestBudget)
+	limit.tokens += float64(now.Sub(limit.updatedAt)) / float64(perToken)
+	if limit.tokens > float64(householdRequestBudget) {
+		limit.tokens = float64(householdRequestBudget)
+	}
+	limit.updatedAt = now
+	if limit.tokens < 1 {
+		budgetWait := time.Duration((1 - limit.tokens) * float64(perToken))
+		if budgetWait > maxRequestWait {
+			limit.deniedUntil = now.Add(budgetWait)
+			l.mu.Unlock()
+			return &APIError{StatusCode: http.StatusTooManyRequests, Reason: fmt.Sprintf("request budget of household is used up, retry in %s", budgetWait.Round(time.Second)), kind: ErrRateLimited}
+		}
+		if budgetWait > wait {
+			wait = budgetWait
+		}
+	}
+	limit.tokens--
+	l.mu.Unlock()
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			l.mu.Lock()
+			limit.tokens++
+			l.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Throttled pauses the household after Sonos responded with 429
+func (l *rateLimiter) Throttled(householdID string, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit := l.household(householdID)
+	if until := time.Now().Add(retryAfter); until.After(limit.pausedUntil) {
+		limit.pausedUntil = until
+	}
+}
+
+// retryBackoff returns the pause before the retry after a network or server error, it doubles with every retry of the
+// request and is never longer than maxRequestWait
+func retryBackoff(retry int) time.Duration {
+	pause := maxRequestWait
+	if retry < 16 && backoffBase<<uint(retry) < maxRequestWait {
+		pause = backoffBase << uint(retry)
+	}
+	// jitter spreads retries of different requests and adapters
+	return pause/2 + time.Duration(rand.Int63n(int64(pause/2)+1))
+}
+
+// IsThrottled returns true if requests of any household are held back because of 429 or used up budget
+func (l *rateLimiter) IsThrottled() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for _, limit := range l.households {
+		if now.Before(limit.deniedUntil) || now.Before(limit.pausedUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter reads Retry-After header of 429 response, it is either seconds or HTTP date
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && time.Until(date) > 0 {
+		return time.Until(date)
+	}
+	return defaultRetryAfter
+}
+
+// sleepContext waits for the pause or until ctx is done
+func sleepContext(ctx context.Context, pause time.Duration) error {
+	timer := time.NewTimer(pause)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,140 @@
+package sonos
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClient_RetryAfter(t *testing.T) {
+	requests := 0
+	client, server := newCloudTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	defer server.Close()
+	client.RestoreTopology([]Group{{GroupId: "RINCON_A:1", HouseholdId: "Sonos_1"}}, nil)
+
+	if _, err := client.VolumeGet(context.Background(), "RINCON_A:1"); !errors.Is(err, ErrRateLimited) {
+		t.Fatal("429 must be returned as rate limited error, got", err)
+	}
+	if !client.IsThrottled() {
+		t.Fatal("Client must be throttled")
+	}
+	if _, err := client.FavoritesGet(context.Background(), "Sonos_1"); !errors.Is(err, ErrRateLimited) || requests != 1 {
+		t.Fatalf("Requests of the household must not be sent until Retry-After, got %d requests, err: %v", requests, err)
+	}
+	// other households are not affected
+	if _, err := client.FavoritesGet(context.Background(), "Sonos_2"); requests != 2 {
+		t.Fatalf("Request of other household must be sent, got %d requests, err: %v", requests, err)
+	}
+}
+
+func TestClient_BackoffOnServerErrors(t *testing.T) {
+	defer func(base time.Duration) { backoffBase = base }(backoffBase)
+	backoffBase = 10 * time.Millisecond
+	requests := map[string]int{}
+	client, server := newCloudTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests[r.Method]++
+		if requests[r.Method] <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"volume": 20}`))
+	})
+	defer server.Close()
+
+	volume, err := client.VolumeGet(context.Background(), "RINCON_A:1")
+	if err != nil || volume.Volume != 20 || requests[http.MethodGet] != 3 {
+		t.Fatalf("GET must be retried, got %d requests, err: %v", requests[http.MethodGet], err)
+	}
+	// commands are not retried, they might have been executed
+	if _, err := client.PlaybackSeek(context.Background(), 1000, "RINCON_A:1"); err == nil || requests[http.MethodPost] != 1 {
+		t.Fatalf("POST must not be retried, got %d requests, err: %v", requests[http.MethodPost], err)
+	}
+	if client.IsThrottled() {
+		t.Fatal("Server errors must not be reported as throttling")
+	}
+	// errors of one request don't hold back the other commands of the household
+	if _, err := client.PlaybackSeek(context.Background(), 1000, "RINCON_B:1"); requests[http.MethodPost] != 2 {
+		t.Fatalf("Command after server errors must be sent, got %d requests, err: %v", requests[http.MethodPost], err)
+	}
+}
+
+func TestClient_RetryShortRateLimit(t *testing.T) {
+	defer func(retryAfter time.Duration) { defaultRetryAfter = retryAfter }(defaultRetryAfter)
+	defaultRetryAfter = 10 * time.Millisecond
+	requests := 0
+	client, server := newCloudTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	defer server.Close()
+
+	// 429 means the command was not executed, it is retried after Retry-After within maxRequestWait
+	if _, err := client.PlaybackSeek(context.Background(), 1000, "RINCON_A:1"); err != nil || requests != 2 {
+		t.Fatalf("Command must be retried after short 429, got %d requests, err: %v", requests, err)
+	}
+}
+
+func TestRateLimiter_Budget(t *testing.T) {
+	defer func(budget int, wait time.Duration) { householdRequestBudget, maxRequestWait = budget, wait }(householdRequestBudget, maxRequestWait)
+	householdRequestBudget = 3
+	maxRequestWait = 10 * time.Millisecond
+	limiter := newRateLimiter()
+	limiter.SetTopology("Sonos_1", []Group{{GroupId: "RINCON_A:1"}}, []Player{{Id: "RINCON_B"}})
+
+	if household := limiter.householdOf("/control/api/v1/players/RINCON_B/playerVolume"); household != "Sonos_1" {
+		t.Fatalf("Player request must use the budget of its household, got %q", household)
+	}
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background(), "Sonos_1"); err != nil {
+			t.Fatal("Request within budget must be allowed, err:", err)
+		}
+	}
+	if err := limiter.Wait(context.Background(), "Sonos_1"); !errors.Is(err, ErrRateLimited) {
+		t.Fatal("Request over budget must be rejected, got", err)
+	}
+	if !limiter.IsThrottled() {
+		t.Fatal("Used up budget must be reported as throttling")
+	}
+	if err := limiter.Wait(context.Background(), "Sonos_2"); err != nil {
+		t.Fatal("Budget is per household, err:", err)
+	}
+}
+
+func TestRateLimiter_CanceledWaitRefundsBudget(t *testing.T) {
+	defer func(budget int) { householdRequestBudget = budget }(householdRequestBudget)
+	householdRequestBudget = 1000
+	limiter := newRateLimiter()
+	for i := 0; i < 1000; i++ {
+		limiter.Wait(context.Background(), "Sonos_1")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tokens := limiter.household("Sonos_1").tokens
+	if err := limiter.Wait(ctx, "Sonos_1"); err != context.Canceled {
+		t.Fatal("Canceled request must fail, got", err)
+	}
+	// tokens are added over time, the canceled request must not take one
+	if after := limiter.household("Sonos_1").tokens; after < tokens {
+		t.Fatalf("Canceled request must not use budget, tokens %f before and %f after", tokens, after)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var pauses []time.Duration
+	for i := 0; i < 12; i++ {
+		pauses = append(pauses, retryBackoff(i))
+	}
+	if pauses[0] < backoffBase/2 || pauses[0] > backoffBase || pauses[2] < 2*backoffBase || pauses[11] > maxRequestWait {
+		t.Fatalf("Unexpected pauses %v", pauses)
+	}
+}
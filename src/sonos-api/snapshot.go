@@ -0,0 +1,267 @@
+package sonos
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// clipPollInterval and clipMaxDuration control how long PlayClipWithRestore waits for the stream to finish
+	clipPollInterval = time.Second
+	clipMaxDuration  = 3 * time.Minute
+)
+
+// GroupSnapshot is the state of a group which can be restored after it was interrupted
+type GroupSnapshot struct {
+	GroupId        string
+	HouseholdId    string
+	PlaybackState  string
+	PositionMillis int
+	CanSeek        bool
+	PlayModes      map[string]bool
+	Volume         int
+	Muted          bool
+	Container      Container
+	// CoordinatorId and PlayerIds are set when group members must be restored as well
+	CoordinatorId string
+	PlayerIds     []string
+	// FavoriteId or PlaylistId is set when the container was found in household favorites or playlists
+	FavoriteId     string
+	PlaylistId     string
+	LineInDeviceId string
+}
+
+// IsRestorable returns true if RestoreSnapshot can load the saved content again
+func (s *GroupSnapshot) IsRestorable() bool {
+	return s.FavoriteId != "" || s.PlaylistId != "" || s.LineInDeviceId != ""
+}
+
+// TakeSnapshot reads playback state, position, volume and current content of the group
+func (clt *Client) TakeSnapshot(ctx context.Context, groupId, householdId string) (*GroupSnapshot, error) {
+	pbStatus, err := clt.PlaybackGetStatus(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	volume, err := clt.VolumeGet(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := clt.GetMetadata(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &GroupSnapshot{
+		GroupId:        groupId,
+		HouseholdId:    householdId,
+		PlaybackState:  pbStatus.PlaybackState,
+		PositionMillis: pbStatus.PositionMillis,
+		CanSeek:        pbStatus.AvailablePlaybackActions.CanSeek,
+		Volume:         volume.Volume,
+		Muted:          volume.Muted,
+		Container:      metadata.Container,
+	}
+	snapshot.PlayModes = map[string]bool{
+		"repeat":     pbStatus.PlayModes.Repeat,
+		"repeat_one": pbStatus.PlayModes.RepeatOne,
+		"shuffle":    pbStatus.PlayModes.Shuffle,
+		"crossfade":  pbStatus.PlayModes.Crossfade,
+	}
+	if strings.HasPrefix(metadata.Container.Type, "linein") {
+		// object id of line-in container is the id of the source player
+		if strings.HasPrefix(metadata.Container.ID.ObjectID, "RINCON_") {
+			snapshot.LineInDeviceId = metadata.Container.ID.ObjectID
+		}
+		return snapshot, nil
+	}
+	if metadata.Container.Name == "" {
+		return snapshot, nil
+	}
+	// Control API can't load arbitrary containers, content is restored only if it is a favorite or a playlist
+	if favorites, err := clt.FavoritesGet(ctx, householdId); err == nil {
+		for _, favorite := range favorites {
+			if favorite.Name == metadata.Container.Name {
+				snapshot.FavoriteId = favorite.ID
+				return snapshot, nil
+			}
+		}
+	}
+	if playlists, err := clt.PlaylistsGet(ctx, householdId); err == nil {
+		for _, playlist := range playlists {
+			if playlist.Name == metadata.Container.Name {
+				snapshot.PlaylistId = playlist.ID
+				break
+			}
+		}
+	}
+	return snapshot, nil
+}
+
+// TakeGroupSnapshot is TakeSnapshot which also saves group members, so RestoreSnapshot regroups the players
+func (clt *Client) TakeGroupSnapshot(ctx context.Context, group Group) (*GroupSnapshot, error) {
+	snapshot, err := clt.TakeSnapshot(ctx, group.GroupId, group.HouseholdId)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.CoordinatorId = group.CoordinatorId
+	for _, id := range group.PlayersIds {
+		snapshot.PlayerIds = append(snapshot.PlayerIds, fmt.Sprintf("%v", id))
+	}
+	return snapshot, nil
+}
+
+// RestoreSnapshot loads the content, position, play modes, volume and play state saved by TakeSnapshot.
+// If members were saved, snapshot.GroupId must be the current group of the coordinator, it is updated to the id of regrouped group.
+func (clt *Client) RestoreSnapshot(ctx context.Context, snapshot *GroupSnapshot) error {
+	var err error
+	if len(snapshot.PlayerIds) > 0 {
+		group, err := clt.SetGroupMembers(ctx, snapshot.HouseholdId, snapshot.GroupId, snapshot.PlayerIds)
+		if err != nil {
+			return err
+		}
+		snapshot.GroupId = group.GroupId
+	}
+	restored := snapshot.IsRestorable()
+	switch {
+	case snapshot.FavoriteId != "":
+		_, err = clt.FavoriteSet(ctx, snapshot.FavoriteId, snapshot.GroupId)
+	case snapshot.PlaylistId != "":
+		_, err = clt.PlaylistSet(ctx, snapshot.PlaylistId, snapshot.GroupId)
+	case snapshot.LineInDeviceId != "":
+		_, err = clt.LoadLineIn(ctx, snapshot.LineInDeviceId, true, snapshot.GroupId)
+	default:
+		log.Infof("<client> Content %q of group %s can't be restored, only play state is resumed", snapshot.Container.Name, snapshot.GroupId)
+	}
+	if err != nil {
+		return err
+	}
+	if restored && snapshot.CanSeek && snapshot.PositionMillis > 0 {
+		if _, err := clt.PlaybackSeek(ctx, int64(snapshot.PositionMillis), snapshot.GroupId); err != nil {
+			log.Warn("<client> Can't restore position. Err:", err.Error())
+		}
+	}
+	if restored && len(snapshot.PlayModes) > 0 {
+		modes := make(map[string]bool, len(snapshot.PlayModes))
+		for mode, enabled := range snapshot.PlayModes {
+			modes[mode] = enabled
+		}
+		if _, err := clt.PlaybackModeSet(ctx, modes, snapshot.GroupId); err != nil {
+			log.Warn("<client> Can't restore play modes. Err:", err.Error())
+		}
+	}
+	if _, err := clt.VolumeSet(ctx, int64(snapshot.Volume), snapshot.GroupId); err != nil {
+		return err
+	}
+	if _, err := clt.VolumeMuteSet(ctx, snapshot.Muted, snapshot.GroupId); err != nil {
+		return err
+	}
+	wasPlaying := snapshot.PlaybackState == "PLAYBACK_STATE_PLAYING" || snapshot.PlaybackState == "PLAYBACK_STATE_BUFFERING"
+	switch {
+	case restored && !wasPlaying:
+		_, err = clt.PlaybackSet(ctx, "pause", snapshot.GroupId)
+	case !restored && wasPlaying:
+		// current content is kept, e.g. the music paused by the flow plays again
+		_, err = clt.PlaybackSet(ctx, "play", snapshot.GroupId)
+	}
+	return err
+}
+
+// StreamClip is a clip played as a stream by PlayClipAsStream, Restore loads the content saved before the clip
+type StreamClip struct {
+	clt          *Client
+	groupId      string
+	playerId     string
+	snapshot     *GroupSnapshot
+	playerVolume *VolumeResponse
+}
+
+// PlayClipWithRestore plays the clip on a group without AUDIO_CLIP capability. Current content is saved, the clip is played
+// as a stream and the content is restored when the stream ends. Clip volume is set on playerId only, other members of
+// the group keep their volume. The call blocks until the group is restored.
+// Content which can't be restored, e.g. a music service queue, would be replaced by the stream. The clip is then played with
+// the audio clip API instead and the returned clip is not nil, if the player rejects it the clip is not played at all.
+func (clt *Client) PlayClipWithRestore(ctx context.Context, req AudioClipRequest, playerId, groupId, householdId string) (*AudioClip, error) {
+	stream, clip, err := clt.PlayClipAsStream(ctx, req, playerId, groupId, householdId)
+	if err != nil || stream == nil {
+		return clip, err
+	}
+	stream.WaitForEnd(ctx)
+	return nil, stream.Restore(ctx)
+}
+
+// PlayClipAsStream saves the group and starts the clip as a stream, it returns without waiting for the clip to end.
+// Either the stream or the audio clip played instead is returned, see PlayClipWithRestore.
+func (clt *Client) PlayClipAsStream(ctx context.Context, req AudioClipRequest, playerId, groupId, householdId string) (*StreamClip, *AudioClip, error) {
+	if req.StreamURL == "" {
+		return nil, nil, fmt.Errorf("clip type %s is not supported by the player", req.ClipType)
+	}
+	snapshot, err := clt.TakeSnapshot(ctx, groupId, householdId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !snapshot.IsRestorable() && (snapshot.Container.Name != "" || snapshot.Container.Type != "") {
+		clip, err := clt.AudioClipPlay(ctx, req, playerId)
+		if err != nil {
+			return nil, nil, fmt.Errorf("content %q of the group can't be restored after the clip and audio clip failed: %w", snapshot.Container.Name, err)
+		}
+		return nil, clip, nil
+	}
+	stream := &StreamClip{clt: clt, groupId: groupId, playerId: playerId, snapshot: snapshot}
+	if req.Volume > 0 {
+		if stream.playerVolume, err = clt.PlayerVolumeGet(ctx, playerId); err != nil {
+			log.Warn("<client> Can't get player volume, clip is played at current volume. Err:", err.Error())
+		} else if _, err := clt.PlayerVolumeSet(ctx, int64(req.Volume), playerId); err != nil {
+			log.Warn("<client> Can't set clip volume. Err:", err.Error())
+		}
+	}
+	if _, err := clt.PlayStreamUrl(ctx, req.StreamURL, StationMetadata{Name: req.Name}, groupId); err != nil {
+		stream.Restore(ctx)
+		return nil, nil, err
+	}
+	return stream, nil, nil
+}
+
+// WaitForEnd returns when the clip stops playing, clipMaxDuration has passed or ctx is done
+func (s *StreamClip) WaitForEnd(ctx context.Context) {
+	s.clt.waitForStreamEnd(ctx, s.groupId)
+}
+
+// Restore loads the content, volume and play state saved before the clip
+func (s *StreamClip) Restore(ctx context.Context) error {
+	// player volume goes first, so the group volume of the snapshot doesn't change other members
+	if s.playerVolume != nil {
+		if _, err := s.clt.PlayerVolumeSet(ctx, int64(s.playerVolume.Volume), s.playerId); err != nil {
+			log.Warn("<client> Can't restore player volume. Err:", err.Error())
+		}
+	}
+	return s.clt.RestoreSnapshot(ctx, s.snapshot)
+}
+
+// waitForStreamEnd returns when the group stops playing, clipMaxDuration has passed or ctx is done.
+// Short clips may end between two polls, so it gives up waiting for start after a few polls.
+func (clt *Client) waitForStreamEnd(ctx context.Context, groupId string) {
+	started := false
+	for i := 0; time.Duration(i)*clipPollInterval < clipMaxDuration; i++ {
+		select {
+		case <-time.After(clipPollInterval):
+		case <-ctx.Done():
+			return
+		}
+		pbStatus, err := clt.PlaybackGetStatus(ctx, groupId)
+		if err != nil {
+			continue
+		}
+		switch pbStatus.PlaybackState {
+		case "PLAYBACK_STATE_PLAYING", "PLAYBACK_STATE_BUFFERING":
+			started = true
+		default:
+			if started || i >= 5 {
+				return
+			}
+		}
+	}
+	log.Warn("<client> Clip is still playing on ", groupId, ", restoring anyway")
+}
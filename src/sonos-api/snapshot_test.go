@@ -0,0 +1,159 @@
+package sonos
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_PlayClipWithRestore(t *testing.T) {
+	clipPollInterval = time.Millisecond
+	defer func() { clipPollInterval = time.Second }()
+
+	var mux sync.Mutex
+	var requests []string
+	statusCalls := 0
+	client, server := newCloudTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		path := strings.TrimPrefix(r.URL.Path, "/control/api/v1")
+		requests = append(requests, r.Method+" "+path)
+		switch {
+		case path == "/groups/RINCON_A:1/playback" && r.Method == http.MethodGet:
+			statusCalls++
+			// first call is the snapshot, then the clip plays for two polls
+			state := "PLAYBACK_STATE_PLAYING"
+			if statusCalls > 3 {
+				state = "PLAYBACK_STATE_IDLE"
+			}
+			w.Write([]byte(`{"playbackState":"` + state + `","positionMillis":5000,"availablePlaybackActions":{"canSeek":true}}`))
+		case path == "/groups/RINCON_A:1/groupVolume" && r.Method == http.MethodGet:
+			w.Write([]byte(`{"volume":20,"muted":false}`))
+		case path == "/players/RINCON_B/playerVolume" && r.Method == http.MethodGet:
+			w.Write([]byte(`{"volume":15,"muted":false}`))
+		case path == "/groups/RINCON_A:1/playbackMetadata":
+			w.Write([]byte(`{"container":{"name":"Morning","type":"playlist"}}`))
+		case path == "/households/HH_1/favorites":
+			w.Write([]byte(`{"items":[{"id":"7","name":"Morning"}]}`))
+		case strings.HasSuffix(path, "/joinOrCreate"):
+			w.Write([]byte(`{"sessionId":"S1"}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	})
+	defer server.Close()
+
+	req := AudioClipRequest{StreamURL: "http://hub/clip.mp3", Volume: 40}
+	if clip, err := client.PlayClipWithRestore(context.Background(), req, "RINCON_B", "RINCON_A:1", "HH_1"); err != nil || clip != nil {
+		t.Fatal("Can't play clip as stream. Err:", err)
+	}
+	expected := []string{
+		"POST /players/RINCON_B/playerVolume",
+		"POST /playbackSessions/S1/playbackSession/loadStreamUrl",
+		"POST /players/RINCON_B/playerVolume",
+		"POST /groups/RINCON_A:1/favorites",
+		"POST /groups/RINCON_A:1/playback/seek",
+	}
+	joined := strings.Join(requests, "\n")
+	last := 0
+	for _, e := range expected {
+		i := strings.Index(joined[last:], e)
+		if i < 0 {
+			t.Fatalf("Request %q missing or out of order in\n%s", e, joined)
+		}
+		last += i + len(e)
+	}
+	if i := strings.Index(joined, "POST /groups/RINCON_A:1/groupVolume"); i < strings.Index(joined, "loadStreamUrl") {
+		t.Fatalf("Clip volume must be set on the player only, got\n%s", joined)
+	}
+	if _, err := client.PlayClipWithRestore(context.Background(), AudioClipRequest{ClipType: ClipTypeChime}, "RINCON_B", "RINCON_A:1", "HH_1"); err == nil {
+		t.Fatal("Chime can't be played without audio clip support")
+	}
+}
+
+func TestClient_PlayClipWithRestoreNotFavorite(t *testing.T) {
+	client, server := newRecordingClient(t)
+	defer server.Close()
+	server.respond("GET /groups/RINCON_A:1/playback", http.StatusOK, `{"playbackState":"PLAYBACK_STATE_PLAYING","positionMillis":5000}`)
+	server.respond("GET /groups/RINCON_A:1/groupVolume", http.StatusOK, `{"volume":20,"muted":false}`)
+	// music service queue, not a favorite or a playlist of the household
+	server.respond("GET /groups/RINCON_A:1/playbackMetadata", http.StatusOK, `{"container":{"name":"Discover Weekly","type":"playlist"}}`)
+	server.respond("POST /players/RINCON_B/audioClip", http.StatusOK, `{"id":"C1","name":"Doorbell","clipType":"CUSTOM","priority":"HIGH"}`)
+
+	req := AudioClipRequest{Name: "Doorbell", StreamURL: "http://hub/clip.mp3", Volume: 40}
+	clip, err := client.PlayClipWithRestore(context.Background(), req, "RINCON_B", "RINCON_A:1", "HH_1")
+	if err != nil || clip == nil || clip.ID != "C1" {
+		t.Fatalf("Clip must be played with audio clip API, got %+v, err: %v", clip, err)
+	}
+	sent := server.sent()
+	if strings.Contains(sent, "loadStreamUrl") || strings.Contains(sent, "POST /groups/") {
+		t.Fatalf("Content which can't be restored must not be replaced, got\n%s", sent)
+	}
+
+	server.reset()
+	server.respond("POST /players/RINCON_B/audioClip", http.StatusBadRequest, `{"errorCode":"ERROR_INVALID_PARAMETER"}`)
+	if _, err := client.PlayClipWithRestore(context.Background(), req, "RINCON_B", "RINCON_A:1", "HH_1"); !errors.Is(err, ErrBadRequest) {
+		t.Fatal("Rejected audio clip must be reported, got ", err)
+	}
+	if sent := server.sent(); strings.Contains(sent, "loadStreamUrl") {
+		t.Fatalf("Stream must not replace content which can't be restored, got\n%s", sent)
+	}
+}
+
+func TestClient_RestoreSnapshotNotFavorite(t *testing.T) {
+	client, server := newRecordingClient(t)
+	defer server.Close()
+
+	snapshot := &GroupSnapshot{GroupId: "RINCON_A:1", PlaybackState: "PLAYBACK_STATE_PLAYING", Volume: 20, Container: Container{Name: "Discover Weekly"}}
+	if err := client.RestoreSnapshot(context.Background(), snapshot); err != nil {
+		t.Fatal("Can't restore snapshot. Err:", err)
+	}
+	expected := []string{
+		"POST /groups/RINCON_A:1/groupVolume",
+		"POST /groups/RINCON_A:1/groupVolume/mute",
+		"POST /groups/RINCON_A:1/playback/play",
+	}
+	if sent := server.sent(); sent != strings.Join(expected, "\n") {
+		t.Fatalf("Previous play state must be resumed, got\n%s", sent)
+	}
+}
+
+func TestClient_RestoreGroupSnapshot(t *testing.T) {
+	client, server := newRecordingClient(t)
+	defer server.Close()
+	server.respond("GET /groups/RINCON_A:1/playback", http.StatusOK, `{"playbackState":"PLAYBACK_STATE_PAUSED","playModes":{"shuffle":true}}`)
+	server.respond("GET /groups/RINCON_A:1/groupVolume", http.StatusOK, `{"volume":20,"muted":true}`)
+	server.respond("GET /groups/RINCON_A:1/playbackMetadata", http.StatusOK, `{"container":{"name":"Line-In","type":"linein","id":{"objectId":"RINCON_B"}}}`)
+	server.respond("POST /groups/RINCON_A:1/groups/setGroupMembers", http.StatusOK, `{"group":{"id":"RINCON_A:5","coordinatorId":"RINCON_A","playerIds":["RINCON_A","RINCON_B"]}}`)
+
+	group := Group{GroupId: "RINCON_A:1", CoordinatorId: "RINCON_A", HouseholdId: "HH_1", PlayersIds: []interface{}{"RINCON_A", "RINCON_B"}}
+	snapshot, err := client.TakeGroupSnapshot(context.Background(), group)
+	if err != nil {
+		t.Fatal("Can't take snapshot. Err:", err)
+	}
+	if snapshot.LineInDeviceId != "RINCON_B" || !snapshot.PlayModes["shuffle"] || len(snapshot.PlayerIds) != 2 {
+		t.Fatalf("Unexpected snapshot %+v", snapshot)
+	}
+	server.reset()
+	if err := client.RestoreSnapshot(context.Background(), snapshot); err != nil {
+		t.Fatal("Can't restore snapshot. Err:", err)
+	}
+	if snapshot.GroupId != "RINCON_A:5" {
+		t.Fatal("Group id must be updated after regrouping, got ", snapshot.GroupId)
+	}
+	expected := []string{
+		"POST /groups/RINCON_A:1/groups/setGroupMembers",
+		"POST /groups/RINCON_A:5/playback/lineIn",
+		"POST /groups/RINCON_A:5/playback/playMode",
+		"POST /groups/RINCON_A:5/groupVolume",
+		"POST /groups/RINCON_A:5/groupVolume/mute",
+		"POST /groups/RINCON_A:5/playback/pause",
+	}
+	if sent := server.sent(); sent != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected requests\n%s", sent)
+	}
+}
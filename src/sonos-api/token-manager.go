@@ -0,0 +1,365 @@
+package sonos
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/futurehomeno/fimpgo/edgeapp"
+	log "github.com/sirupsen/logrus"
+)
+
+// Auth states reported by TokenManager, values are the same as model.AuthState*
+const (
+	AuthStateAuthenticated    = "AUTHENTICATED"
+	AuthStateInProgress       = "IN_PROGRESS"
+	AuthStateNotAuthenticated = "NOT_AUTHENTICATED"
+)
+
+// Auth error codes, they are sent as error_code of evt.auth.status_report
+const (
+	AuthErrorTokenRevoked   = "TOKEN_REVOKED"    // refresh token is invalid or revoked, e.g. the integration was removed in the Sonos account
+	AuthErrorAccessDenied   = "ACCESS_DENIED"    // the partner doesn't have access to the account anymore
+	AuthErrorNoRefreshToken = "NO_REFRESH_TOKEN" // the user is logged out
+	AuthErrorRefreshFailed  = "REFRESH_FAILED"   // network or server error, the refresh is retried
+)
+
+var (
+	// defaultTokenLifetime is used when expires_in is unknown
+	defaultTokenLifetime = 12 * time.Hour
+	// refreshMargin is how long before expiry the access token is refreshed
+	refreshMargin = 10 * time.Minute
+	// refreshRetryInterval is the delay between failed scheduled refreshes
+	refreshRetryInterval = time.Minute
+	// idleCheckInterval is how often the scheduler wakes up when there is nothing to refresh
+	idleCheckInterval = time.Hour
+	// maxRefreshRejections is the number of consecutive refreshes rejected with bare 400, 401 or 403 after which the refresh
+	// token is given up. A single rejection can be a malformed request or a transient error of the auth proxy.
+	maxRefreshRejections = 3
+)
+
+// refreshStatusRe finds HTTP status in errors of edgeapp.FhOAuth2Client, e.g. "error 400 Bad Request response from server"
+var refreshStatusRe = regexp.MustCompile(`error (\d{3}) `)
+
+// AuthError is a classified error of a token refresh
+type AuthError struct {
+	Code      string
+	Permanent bool // the user has to log in again, the refresh is not retried until new tokens are set
+	Err       error
+	// rejectedAs is the code used when the refresh was rejected with 400, 401 or 403 maxRefreshRejections times in a row
+	rejectedAs string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Code, e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrUnauthorized) true for all auth errors
+func (e *AuthError) Is(target error) bool {
+	return target == ErrUnauthorized
+}
+
+// IsPermanentAuthError returns true if the request failed because the user has to log in again
+func IsPermanentAuthError(err error) bool {
+	var authErr *AuthError
+	return errors.As(err, &authErr) && authErr.Permanent
+}
+
+// classifyRefreshError tells revoked refresh tokens from other errors, which are worth retrying. Only invalid_grant and
+// revoked are permanent right away, other rejections become permanent when they repeat, see maxRefreshRejections.
+func classifyRefreshError(err error) *AuthError {
+	authErr := &AuthError{Code: AuthErrorRefreshFailed, Err: err}
+	text := strings.ToLower(err.Error())
+	if strings.Contains(text, "invalid_grant") || strings.Contains(text, "revoked") {
+		authErr.Code, authErr.Permanent = AuthErrorTokenRevoked, true
+		return authErr
+	}
+	if match := refreshStatusRe.FindStringSubmatch(text); match != nil {
+		switch match[1] {
+		case "400", "401":
+			authErr.rejectedAs = AuthErrorTokenRevoked
+		case "403":
+			authErr.rejectedAs = AuthErrorAccessDenied
+		}
+	}
+	return authErr
+}
+
+// Tokens are OAuth2 tokens of the Sonos account
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// NewTokens creates tokens which expire in expiresIn seconds from now, 0 means unknown lifetime
+func NewTokens(accessToken, refreshToken string, expiresIn int) Tokens {
+	lifetime := time.Duration(expiresIn) * time.Second
+	if expiresIn <= 0 {
+		lifetime = defaultTokenLifetime
+	}
+	return Tokens{AccessToken: accessToken, RefreshToken: refreshToken, ExpiresAt: time.Now().Add(lifetime)}
+}
+
+// TokenRefresher exchanges refresh token for new tokens, it is implemented by edgeapp.FhOAuth2Client
+type TokenRefresher interface {
+	ExchangeRefreshToken(refreshToken string) (*edgeapp.OAuth2TokenResponse, error)
+}
+
+// TokenManager keeps access token valid. It refreshes the token before it expires and when the API responds with 401,
+// concurrent refreshes are serialized, so a rotated refresh token is used only once.
+type TokenManager struct {
+	mu           sync.Mutex // protects tokens, state, retryAt, authErr, rejections and handlers
+	refreshMu    sync.Mutex // serializes refreshes
+	refresher    TokenRefresher
+	tokens       Tokens
+	state        string
+	retryAt      time.Time
+	authErr      *AuthError // permanent refresh error, it is cleared by SetTokens
+	rejections   int        // consecutive refreshes rejected with 400, 401 or 403
+	rejected     *AuthError // the last rejection, refresh is not sent again before retryAt
+	persist      func(tokens Tokens)
+	stateChanged func(state string, authErr *AuthError)
+	resetCh      chan struct{}
+	stopCh       chan struct{}
+}
+
+func NewTokenManager(refresher TokenRefresher, tokens Tokens) *TokenManager {
+	tm := &TokenManager{refresher: refresher, tokens: tokens, resetCh: make(chan struct{}, 1)}
+	tm.state = AuthStateNotAuthenticated
+	if tokens.AccessToken != "" {
+		tm.state = AuthStateAuthenticated
+	}
+	return tm
+}
+
+// SetPersistHandler sets function which saves tokens after every refresh
+func (tm *TokenManager) SetPersistHandler(persist func(tokens Tokens)) {
+	tm.mu.Lock()
+	tm.persist = persist
+	tm.mu.Unlock()
+}
+
+// SetStateHandler sets function which is called when auth state changes. authErr is set when the state changes to
+// NOT_AUTHENTICATED because of a failed refresh.
+func (tm *TokenManager) SetStateHandler(stateChanged func(state string, authErr *AuthError)) {
+	tm.mu.Lock()
+	tm.stateChanged = stateChanged
+	tm.mu.Unlock()
+}
+
+// SetTokens replaces tokens after login or logout, empty tokens mean the user is logged out
+func (tm *TokenManager) SetTokens(tokens Tokens) {
+	tm.mu.Lock()
+	tm.tokens = tokens
+	tm.retryAt = time.Time{}
+	tm.authErr = nil
+	tm.rejections, tm.rejected = 0, nil
+	tm.mu.Unlock()
+	if tokens.AccessToken != "" {
+		tm.setState(AuthStateAuthenticated, nil)
+	} else {
+		tm.setState(AuthStateNotAuthenticated, nil)
+	}
+	tm.reset()
+}
+
+func (tm *TokenManager) Tokens() Tokens {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return tm.tokens
+}
+
+func (tm *TokenManager) AccessToken() string {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return tm.tokens.AccessToken
+}
+
+func (tm *TokenManager) State() string {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return tm.state
+}
+
+// LastError returns the permanent refresh error, nil if the tokens can still be used
+func (tm *TokenManager) LastError() *AuthError {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return tm.authErr
+}
+
+// recentRejection returns the last rejected refresh if the retry is not due yet
+func (tm *TokenManager) recentRejection() *AuthError {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.rejected != nil && time.Now().Before(tm.retryAt) {
+		return tm.rejected
+	}
+	return nil
+}
+
+// Refresh exchanges refresh token for a new access token. staleToken is the access token which was rejected, if it was
+// already replaced by another refresh the current token is returned without refreshing again. Empty staleToken forces refresh.
+func (tm *TokenManager) Refresh(staleToken string) (string, error) {
+	tm.refreshMu.Lock()
+	defer tm.refreshMu.Unlock()
+	tokens := tm.Tokens()
+	if staleToken != "" && tokens.AccessToken != staleToken {
+		return tokens.AccessToken, nil
+	}
+	if tokens.RefreshToken == "" {
+		return "", &AuthError{Code: AuthErrorNoRefreshToken, Permanent: true, Err: fmt.Errorf("empty refresh token")}
+	}
+	if authErr := tm.LastError(); authErr != nil {
+		// the refresh token was rejected, asking again won't help
+		return "", authErr
+	}
+	if rejected := tm.recentRejection(); rejected != nil {
+		// rejections are counted at most once per refreshRetryInterval, so retried requests don't use them up
+		return "", rejected
+	}
+	tm.setState(AuthStateInProgress, nil)
+	resp, err := tm.refresher.ExchangeRefreshToken(tokens.RefreshToken)
+	if err == nil && (resp == nil || resp.AccessToken == "") {
+		err = fmt.Errorf("refresh response has no access token")
+	}
+	if err != nil {
+		authErr := classifyRefreshError(err)
+		log.Errorf("<tokens> Can't refresh access token, code %s. Err: %v", authErr.Code, err)
+		tm.mu.Lock()
+		if tm.tokens.RefreshToken != tokens.RefreshToken {
+			// tokens were replaced during the refresh, the error is about the old ones
+			tm.mu.Unlock()
+			return "", authErr
+		}
+		if authErr.rejectedAs != "" {
+			tm.rejections++
+			tm.rejected = authErr
+			if tm.rejections >= maxRefreshRejections {
+				authErr.Code, authErr.Permanent = authErr.rejectedAs, true
+			}
+		}
+		if authErr.Permanent {
+			tm.authErr = authErr
+		} else {
+			tm.retryAt = time.Now().Add(refreshRetryInterval)
+		}
+		tm.mu.Unlock()
+		if !authErr.Permanent && (tokens.ExpiresAt.IsZero() || time.Now().Before(tokens.ExpiresAt)) {
+			// the old token is still valid
+			tm.setState(AuthStateAuthenticated, nil)
+		} else {
+			tm.setState(AuthStateNotAuthenticated, authErr)
+		}
+		return "", authErr
+	}
+	newTokens := NewTokens(resp.AccessToken, tokens.RefreshToken, int(resp.ExpiresIn))
+	if resp.RefreshToken != "" {
+		// refresh token is rotated, the old one can't be used anymore
+		newTokens.RefreshToken = resp.RefreshToken
+	}
+
+	tm.mu.Lock()
+	if tm.tokens.RefreshToken != tokens.RefreshToken {
+		// tokens were replaced by login or logout during the refresh
+		tm.mu.Unlock()
+		return tm.AccessToken(), nil
+	}
+	tm.tokens = newTokens
+	tm.retryAt = time.Time{}
+	tm.rejections, tm.rejected = 0, nil
+	persist := tm.persist
+	tm.mu.Unlock()
+
+	log.Infof("<tokens> Access token refreshed, it expires at %s", newTokens.ExpiresAt.Format(time.RFC3339))
+	if persist != nil {
+		persist(newTokens)
+	}
+	tm.setState(AuthStateAuthenticated, nil)
+	tm.reset()
+	return newTokens.AccessToken, nil
+}
+
+// Start refreshes the access token in background shortly before it expires
+func (tm *TokenManager) Start() {
+	tm.mu.Lock()
+	if tm.stopCh != nil {
+		tm.mu.Unlock()
+		return
+	}
+	stopCh := make(chan struct{})
+	tm.stopCh = stopCh
+	tm.mu.Unlock()
+
+	go func() {
+		for {
+			timer := time.NewTimer(tm.nextRefresh())
+			select {
+			case <-stopCh:
+				timer.Stop()
+				return
+			case <-tm.resetCh:
+				timer.Stop()
+				continue
+			case <-timer.C:
+			}
+			if tm.nextRefresh() > 0 {
+				continue
+			}
+			tm.Refresh(tm.AccessToken())
+		}
+	}()
+}
+
+func (tm *TokenManager) Stop() {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.stopCh != nil {
+		close(tm.stopCh)
+		tm.stopCh = nil
+	}
+}
+
+// nextRefresh returns time until the next scheduled refresh, refresh is due if it is not positive
+func (tm *TokenManager) nextRefresh() time.Duration {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.tokens.RefreshToken == "" || tm.tokens.ExpiresAt.IsZero() || tm.authErr != nil {
+		return idleCheckInterval
+	}
+	refreshAt := tm.tokens.ExpiresAt.Add(-refreshMargin)
+	if refreshAt.Before(tm.retryAt) {
+		refreshAt = tm.retryAt
+	}
+	return time.Until(refreshAt)
+}
+
+func (tm *TokenManager) setState(state string, authErr *AuthError) {
+	tm.mu.Lock()
+	if tm.state == state {
+		tm.mu.Unlock()
+		return
+	}
+	tm.state = state
+	stateChanged := tm.stateChanged
+	tm.mu.Unlock()
+	if stateChanged != nil {
+		stateChanged(state, authErr)
+	}
+}
+
+// reset wakes up the scheduler, so it recalculates the refresh time
+func (tm *TokenManager) reset() {
+	select {
+	case tm.resetCh <- struct{}{}:
+	default:
+	}
+}
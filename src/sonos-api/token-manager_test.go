@@ -0,0 +1,254 @@
+package sonos
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/futurehomeno/fimpgo/edgeapp"
+)
+
+// fakeRefresher returns access token "access_N" and rotated refresh token "refresh_N" for the N-th refresh
+type fakeRefresher struct {
+	mu            sync.Mutex
+	calls         int
+	refreshTokens []string
+	err           error
+}
+
+func (f *fakeRefresher) ExchangeRefreshToken(refreshToken string) (*edgeapp.OAuth2TokenResponse, error) {
+	time.Sleep(10 * time.Millisecond)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	f.refreshTokens = append(f.refreshTokens, refreshToken)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &edgeapp.OAuth2TokenResponse{
+		AccessToken:  fmt.Sprintf("access_%d", f.calls),
+		RefreshToken: fmt.Sprintf("refresh_%d", f.calls),
+		ExpiresIn:    86400,
+	}, nil
+}
+
+func TestTokenManager_ConcurrentRefresh(t *testing.T) {
+	refresher := &fakeRefresher{}
+	tm := NewTokenManager(refresher, Tokens{AccessToken: "access_0", RefreshToken: "refresh_0"})
+	var persisted []Tokens
+	tm.SetPersistHandler(func(tokens Tokens) { persisted = append(persisted, tokens) })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := tm.Refresh("access_0")
+			if err != nil || token != "access_1" {
+				t.Errorf("Unexpected token %s, err: %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if refresher.calls != 1 {
+		t.Fatalf("Rejected token must be refreshed once, got %d refreshes", refresher.calls)
+	}
+	tokens := tm.Tokens()
+	if tokens.RefreshToken != "refresh_1" || time.Until(tokens.ExpiresAt) < 23*time.Hour {
+		t.Fatalf("Unexpected tokens %+v", tokens)
+	}
+	if len(persisted) != 1 || persisted[0] != tokens {
+		t.Fatalf("New tokens must be persisted, got %+v", persisted)
+	}
+
+	// the next refresh must use the rotated refresh token
+	if _, err := tm.Refresh(""); err != nil || refresher.refreshTokens[1] != "refresh_1" {
+		t.Fatalf("Rotated refresh token is not used, got %v, err: %v", refresher.refreshTokens, err)
+	}
+}
+
+func TestTokenManager_ScheduledRefresh(t *testing.T) {
+	refresher := &fakeRefresher{}
+	tm := NewTokenManager(refresher, Tokens{AccessToken: "access_0", RefreshToken: "refresh_0", ExpiresAt: time.Now().Add(time.Minute)})
+	var mu sync.Mutex
+	var states []string
+	tm.SetStateHandler(func(state string, authErr *AuthError) {
+		mu.Lock()
+		states = append(states, state)
+		mu.Unlock()
+	})
+	tm.Start()
+	defer tm.Stop()
+
+	// the token expires within refreshMargin, so it is refreshed right away
+	deadline := time.Now().Add(2 * time.Second)
+	for tm.AccessToken() != "access_1" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if tm.AccessToken() != "access_1" {
+		t.Fatal("Token must be refreshed before it expires")
+	}
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) != 2 || states[0] != AuthStateInProgress || states[1] != AuthStateAuthenticated {
+		t.Fatalf("Unexpected auth states %v", states)
+	}
+	if refresher.calls != 1 {
+		t.Fatalf("New token must not be refreshed again, got %d refreshes", refresher.calls)
+	}
+}
+
+func TestTokenManager_FailedRefresh(t *testing.T) {
+	refresher := &fakeRefresher{err: fmt.Errorf("error 503 Service Unavailable response from server")}
+	tm := NewTokenManager(refresher, Tokens{AccessToken: "access_0", RefreshToken: "refresh_0", ExpiresAt: time.Now().Add(-time.Minute)})
+	if _, err := tm.Refresh("access_0"); err == nil {
+		t.Fatal("Refresh must fail")
+	}
+	if tm.State() != AuthStateNotAuthenticated {
+		t.Fatalf("Expired token which can't be refreshed must not be authenticated, got %s", tm.State())
+	}
+	if next := tm.nextRefresh(); next <= 0 || next > refreshRetryInterval {
+		t.Fatalf("Failed refresh must be retried after %s, got %s", refreshRetryInterval, next)
+	}
+	if tm.LastError() != nil {
+		t.Fatal("Server error must not log the user out")
+	}
+}
+
+func TestTokenManager_RevokedRefreshToken(t *testing.T) {
+	refresher := &fakeRefresher{err: fmt.Errorf(`error 400 Bad Request response from server: {"error":"invalid_grant"}`)}
+	tm := NewTokenManager(refresher, Tokens{AccessToken: "access_0", RefreshToken: "refresh_0", ExpiresAt: time.Now().Add(time.Hour)})
+	var reported []*AuthError
+	tm.SetStateHandler(func(state string, authErr *AuthError) {
+		if state == AuthStateNotAuthenticated {
+			reported = append(reported, authErr)
+		}
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := tm.Refresh(""); !IsPermanentAuthError(err) {
+			t.Fatalf("Rejected refresh token must be a permanent error, got %v", err)
+		}
+	}
+	if refresher.calls != 1 {
+		t.Fatalf("Rejected refresh token must not be sent again, got %d refreshes", refresher.calls)
+	}
+	if tm.State() != AuthStateNotAuthenticated || len(reported) != 1 || reported[0].Code != AuthErrorTokenRevoked {
+		t.Fatalf("Unexpected state %s, reported errors %v", tm.State(), reported)
+	}
+	if next := tm.nextRefresh(); next != idleCheckInterval {
+		t.Fatalf("Rejected refresh token must not be scheduled, got %s", next)
+	}
+
+	// new login clears the error
+	refresher.err = nil
+	tm.SetTokens(NewTokens("access_new", "refresh_new", 3600))
+	if tm.LastError() != nil || tm.State() != AuthStateAuthenticated {
+		t.Fatalf("New tokens must be used, state %s", tm.State())
+	}
+	if token, err := tm.Refresh(""); err != nil || token != "access_2" {
+		t.Fatalf("Unexpected token %s, err: %v", token, err)
+	}
+}
+
+func TestTokenManager_RepeatedRejection(t *testing.T) {
+	refresher := &fakeRefresher{err: fmt.Errorf("error 401 Unauthorized response from server")}
+	tm := NewTokenManager(refresher, Tokens{AccessToken: "access_0", RefreshToken: "refresh_0", ExpiresAt: time.Now().Add(time.Hour)})
+
+	for i := 1; i < maxRefreshRejections; i++ {
+		_, err := tm.Refresh("")
+		if err == nil || IsPermanentAuthError(err) {
+			t.Fatalf("Single rejection must be retried, got %v", err)
+		}
+		// retried requests don't refresh again before the retry is due
+		if _, err := tm.Refresh(""); err == nil || refresher.calls != i {
+			t.Fatalf("Rejected refresh must not be sent before retry, got %d refreshes", refresher.calls)
+		}
+		if tm.LastError() != nil || tm.State() != AuthStateAuthenticated {
+			t.Fatalf("Valid token must still be used, state %s", tm.State())
+		}
+		tm.mu.Lock()
+		tm.retryAt = time.Now()
+		tm.mu.Unlock()
+	}
+	if _, err := tm.Refresh(""); !IsPermanentAuthError(err) {
+		t.Fatalf("Repeated rejection must be permanent, got %v", err)
+	}
+	if authErr := tm.LastError(); authErr == nil || authErr.Code != AuthErrorTokenRevoked {
+		t.Fatalf("Unexpected error %v", authErr)
+	}
+}
+
+func TestClassifyRefreshError(t *testing.T) {
+	tests := []struct {
+		err       string
+		code      string
+		permanent bool
+	}{
+		{"error 400 Bad Request response from server", AuthErrorRefreshFailed, false},
+		{"error 401 Unauthorized response from server", AuthErrorRefreshFailed, false},
+		{"error 403 Forbidden response from server", AuthErrorRefreshFailed, false},
+		{"invalid_grant: refresh token revoked", AuthErrorTokenRevoked, true},
+		{"error 502 Bad Gateway response from server", AuthErrorRefreshFailed, false},
+		{"dial tcp: i/o timeout", AuthErrorRefreshFailed, false},
+		{"empty hub token.operation aborted", AuthErrorRefreshFailed, false},
+	}
+	for _, test := range tests {
+		authErr := classifyRefreshError(fmt.Errorf(test.err))
+		if authErr.Code != test.code || authErr.Permanent != test.permanent {
+			t.Errorf("%q classified as %s (permanent %v)", test.err, authErr.Code, authErr.Permanent)
+		}
+	}
+}
+
+func TestClient_RefreshOnUnauthorized(t *testing.T) {
+	var bodies []string
+	client, server := newCloudTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if r.Header.Get("Authorization") != "Bearer access_1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	defer server.Close()
+	refresher := &fakeRefresher{}
+	client.tokens = NewTokenManager(refresher, Tokens{AccessToken: "token", RefreshToken: "refresh"})
+
+	if _, err := client.VolumeSet(context.Background(), 20, "RINCON_A:1"); err != nil {
+		t.Fatal("Request must be retried with new token. Err:", err)
+	}
+	if len(bodies) != 2 || bodies[0] != bodies[1] || bodies[1] == "" {
+		t.Fatalf("Request body must be sent again, got %q", bodies)
+	}
+	if refresher.calls != 1 || client.Tokens().Tokens().RefreshToken != "refresh_1" {
+		t.Fatalf("Unexpected refresh, calls: %d", refresher.calls)
+	}
+}
+
+func TestClient_StopsAfterRevokedToken(t *testing.T) {
+	requests := 0
+	client, server := newCloudTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	defer server.Close()
+	refresher := &fakeRefresher{err: fmt.Errorf(`error 400 Bad Request response from server: {"error":"invalid_grant"}`)}
+	client.tokens = NewTokenManager(refresher, Tokens{AccessToken: "token", RefreshToken: "refresh"})
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.GetHousehold(context.Background()); !IsPermanentAuthError(err) {
+			t.Fatalf("Request must fail with auth error, got %v", err)
+		}
+	}
+	if requests != 1 || refresher.calls != 1 {
+		t.Fatalf("No requests must be sent after the refresh token was rejected, got %d requests, %d refreshes", requests, refresher.calls)
+	}
+}
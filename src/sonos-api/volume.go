@@ -1,6 +1,7 @@
 package sonos
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
@@ -13,10 +14,19 @@ type VolumeResponse struct {
 	Fixed  bool `json:"fixed"`
 }
 
-func (clt *Client) VolumeGet(id string) (*VolumeResponse, error) {
-	url := fmt.Sprintf("%s%s%s", "https://api.ws.sonos.com/control/api/v1/groups/", id, "/groupVolume")
-	body, err := clt.doApiRequest(http.MethodGet, url, nil)
+func (clt *Client) VolumeGet(ctx context.Context, id string) (*VolumeResponse, error) {
 	var resp VolumeResponse
+	if sent, err := clt.doLocalRequest(ctx, id, NamespaceGroupVolume, "getVolume", nil, &resp); sent {
+		if err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	}
+	url := fmt.Sprintf("%s%s%s", "https://api.ws.sonos.com/control/api/v1/groups/", id, "/groupVolume")
+	body, err := clt.doApiRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
 	err = json.Unmarshal(body, &resp)
 	if err != nil {
 		log.Error("<client>Can't unmarshalling Volume response : ", err)
@@ -26,14 +36,20 @@ func (clt *Client) VolumeGet(id string) (*VolumeResponse, error) {
 }
 
 // VolumeSet sends request to Sonos to set new volume lvl
-func (clt *Client) VolumeSet(val int64, id string) (bool, error) {
+func (clt *Client) VolumeSet(ctx context.Context, val int64, id string) (bool, error) {
 	url := fmt.Sprintf("%s%s%s", "https://api.ws.sonos.com/control/api/v1/groups/", id, "/groupVolume")
 
 	body := map[string]interface{}{
 		"volume": val,
 	}
+	if sent, err := clt.doLocalRequest(ctx, id, NamespaceGroupVolume, "setVolume", body, nil); sent {
+		return err == nil, err
+	}
 
-	binResponse, err := clt.doApiRequest(http.MethodPost, url, body)
+	binResponse, err := clt.doApiRequest(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return false, err
+	}
 	var response map[string]string
 	err = json.Unmarshal(binResponse, &response)
 	if err != nil {
@@ -45,13 +61,19 @@ func (clt *Client) VolumeSet(val int64, id string) (bool, error) {
 }
 
 // MuteSet sends request to Sonos to mute or unmute speaker
-func (clt *Client) VolumeMuteSet(val bool, id string) (bool, error) {
+func (clt *Client) VolumeMuteSet(ctx context.Context, val bool, id string) (bool, error) {
 	url := fmt.Sprintf("%s%s%s", "https://api.ws.sonos.com/control/api/v1/groups/", id, "/groupVolume/mute")
 
 	body := map[string]interface{}{
 		"muted": val,
 	}
-	binResponse, err := clt.doApiRequest(http.MethodPost, url, body)
+	if sent, err := clt.doLocalRequest(ctx, id, NamespaceGroupVolume, "setMute", body, nil); sent {
+		return err == nil, err
+	}
+	binResponse, err := clt.doApiRequest(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return false, err
+	}
 	var response map[string]string
 	err = json.Unmarshal(binResponse, &response)
 	if err != nil {
@@ -62,3 +84,102 @@ func (clt *Client) VolumeMuteSet(val bool, id string) (bool, error) {
 	return true, nil
 }
 
+// PlayerVolumeGet returns volume of a single player, independent of its group
+func (clt *Client) PlayerVolumeGet(ctx context.Context, playerId string) (*VolumeResponse, error) {
+	var resp VolumeResponse
+	if sent, err := clt.doLocalRequest(ctx, playerId, NamespacePlayerVolume, "getVolume", nil, &resp); sent {
+		if err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	}
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/players/", playerId, "/playerVolume")
+	body, err := clt.doApiRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		log.Error("<client>Can't unmarshalling Volume response : ", err)
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// PlayerVolumeSet sets volume of a single player without changing volume of other group members
+func (clt *Client) PlayerVolumeSet(ctx context.Context, val int64, playerId string) (bool, error) {
+	body := map[string]interface{}{
+		"volume": val,
+	}
+	if sent, err := clt.doLocalRequest(ctx, playerId, NamespacePlayerVolume, "setVolume", body, nil); sent {
+		return err == nil, err
+	}
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/players/", playerId, "/playerVolume")
+	_, err := clt.doApiRequest(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PlayerVolumeMuteSet mutes or unmutes a single player
+func (clt *Client) PlayerVolumeMuteSet(ctx context.Context, val bool, playerId string) (bool, error) {
+	body := map[string]interface{}{
+		"muted": val,
+	}
+	if sent, err := clt.doLocalRequest(ctx, playerId, NamespacePlayerVolume, "setMute", body, nil); sent {
+		return err == nil, err
+	}
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/players/", playerId, "/playerVolume/mute")
+	_, err := clt.doApiRequest(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// VolumeStep changes group volume by delta (-100..100) relative to the current level
+func (clt *Client) VolumeStep(ctx context.Context, delta int64, id string) (bool, error) {
+	if err := checkVolumeDelta(delta); err != nil {
+		return false, err
+	}
+	body := map[string]interface{}{
+		"volumeDelta": delta,
+	}
+	if sent, err := clt.doLocalRequest(ctx, id, NamespaceGroupVolume, "setRelativeVolume", body, nil); sent {
+		return err == nil, err
+	}
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/groups/", id, "/groupVolume/relative")
+	_, err := clt.doApiRequest(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PlayerVolumeStep changes volume of a single player by delta (-100..100) relative to the current level
+func (clt *Client) PlayerVolumeStep(ctx context.Context, delta int64, playerId string) (bool, error) {
+	if err := checkVolumeDelta(delta); err != nil {
+		return false, err
+	}
+	body := map[string]interface{}{
+		"volumeDelta": delta,
+	}
+	if sent, err := clt.doLocalRequest(ctx, playerId, NamespacePlayerVolume, "setRelativeVolume", body, nil); sent {
+		return err == nil, err
+	}
+	url := fmt.Sprintf("%s%s%s%s", controlURL, "/v1/players/", playerId, "/playerVolume/relative")
+	_, err := clt.doApiRequest(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkVolumeDelta rejects steps Sonos doesn't accept, so they are not sent at all
+func checkVolumeDelta(delta int64) error {
+	if delta < -100 || delta > 100 {
+		return &APIError{Reason: fmt.Sprintf("volume step %d is out of range -100..100", delta), kind: ErrBadRequest}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package sonos
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestClient_PlayerVolume(t *testing.T) {
+	client, server := newRecordingClient(t)
+	defer server.Close()
+	server.respond("GET /players/RINCON_B/playerVolume", http.StatusOK, `{"volume":25,"muted":true,"fixed":true}`)
+
+	if ok, err := client.PlayerVolumeSet(context.Background(), 30, "RINCON_B"); !ok || err != nil {
+		t.Fatal("Can't set player volume. Err:", err)
+	}
+	if request := server.last(t); request.String() != "POST /players/RINCON_B/playerVolume" || request.body["volume"] != float64(30) {
+		t.Fatalf("Unexpected request %s %v", request, request.body)
+	}
+	vol, err := client.PlayerVolumeGet(context.Background(), "RINCON_B")
+	if err != nil {
+		t.Fatal("Can't get player volume. Err:", err)
+	}
+	if vol.Volume != 25 || !vol.Muted || !vol.Fixed {
+		t.Fatalf("Volume must be read from the response, got %+v", vol)
+	}
+	if ok, err := client.PlayerVolumeMuteSet(context.Background(), true, "RINCON_B"); !ok || err != nil {
+		t.Fatal("Can't mute player. Err:", err)
+	}
+	if request := server.last(t); request.String() != "POST /players/RINCON_B/playerVolume/mute" || request.body["muted"] != true {
+		t.Fatalf("Unexpected request %s %v", request, request.body)
+	}
+
+	// player left the household
+	server.respond("POST /players/RINCON_B/playerVolume", http.StatusGone, `{"errorCode":"ERROR_RESOURCE_GONE"}`)
+	if ok, err := client.PlayerVolumeSet(context.Background(), 30, "RINCON_B"); ok || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Missing player must be not found error, got %v %v", ok, err)
+	}
+}
+
+func TestClient_VolumeStep(t *testing.T) {
+	client, server := newRecordingClient(t)
+	defer server.Close()
+
+	if ok, err := client.VolumeStep(context.Background(), -5, "RINCON_A:1"); !ok || err != nil {
+		t.Fatal("Can't step group volume. Err:", err)
+	}
+	if request := server.last(t); request.path != "/groups/RINCON_A:1/groupVolume/relative" || request.body["volumeDelta"] != float64(-5) {
+		t.Fatalf("Unexpected request %s %v", request, request.body)
+	}
+	if ok, err := client.PlayerVolumeStep(context.Background(), 3, "RINCON_B"); !ok || err != nil {
+		t.Fatal("Can't step player volume. Err:", err)
+	}
+	if request := server.last(t); request.path != "/players/RINCON_B/playerVolume/relative" || request.body["volumeDelta"] != float64(3) {
+		t.Fatalf("Unexpected request %s %v", request, request.body)
+	}
+	if _, err := client.VolumeStep(context.Background(), 101, "RINCON_A:1"); !errors.Is(err, ErrBadRequest) {
+		t.Fatal("Step out of range must be rejected, got", err)
+	}
+	if _, err := client.PlayerVolumeStep(context.Background(), -150, "RINCON_B"); !errors.Is(err, ErrBadRequest) || len(server.requests()) != 2 {
+		t.Fatalf("Step out of range must not be sent, got %d requests, err: %v", len(server.requests()), err)
+	}
+
+	// fixed volume players reject volume changes
+	server.respond("POST /groups/RINCON_A:1/groupVolume/relative", http.StatusBadRequest, `{"errorCode":"ERROR_INVALID_PARAMETER","reason":"fixed volume"}`)
+	if ok, err := client.VolumeStep(context.Background(), 5, "RINCON_A:1"); ok || !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("Rejected step must be bad request error, got %v %v", ok, err)
+	}
+}